@@ -0,0 +1,46 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/logger"
+)
+
+// Access log formats accepted by LOG_FORMAT
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// textLogFormat is Fiber's default access log line with the request ID added
+const textLogFormat = "${time} | ${status} | ${latency} | ${ip} | ${method} | ${path} | ${locals:requestid} | ${error}\n"
+
+// accessLogger logs one line per request with the method, path, status, latency, IP and
+// request ID: through Fiber's logger for "text", and as a JSON object for "json", for log
+// aggregators.
+// main sets slog's default handler to JSON in that case, so this goes out as JSON too.
+func accessLogger(format string) fiber.Handler {
+	if format != logFormatJSON {
+		return logger.New(logger.Config{Format: textLogFormat})
+	}
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		// Like Fiber's logger, handle the error here, so the status logged is the one sent
+		if err := c.Next(); err != nil {
+			if err := c.App().ErrorHandler(c, err); err != nil {
+				_ = c.SendStatus(fiber.StatusInternalServerError)
+			}
+		}
+		slog.Info("request",
+			"method", c.Method(),
+			"path", c.Path(),
+			"status", c.Response().StatusCode(),
+			"latency_ms", time.Since(start).Milliseconds(),
+			"ip", c.IP(),
+			"request_id", currentRequestID(c),
+		)
+		return nil
+	}
+}
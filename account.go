@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// Errors returned by createUser when the username or email is already taken
+var (
+	errUserExists  = errors.New("user already exists")
+	errEmailExists = errors.New("email already registered")
+)
+
+// createUser inserts user, leaving the check for duplicates to the unique indexes: a lookup
+// before inserting can't stop two registrations for the same name racing each other.
+func createUser(ctx context.Context, db *gorm.DB, user *User) error {
+	err := db.WithContext(ctx).Create(user).Error
+	if !errors.Is(err, gorm.ErrDuplicatedKey) {
+		return err
+	}
+	// The error doesn't say which index it came from, so look for the email that won
+	if user.Email != nil {
+		var count int64
+		if err := db.WithContext(ctx).Unscoped().Model(&User{}).Where("email = ?", *user.Email).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return errEmailExists
+		}
+	}
+	return errUserExists
+}
+
+// registerUser creates an unverified account, returning it with the token for its verification
+// link. If the details are invalid, or the username or email is taken, the ValidationResult
+// says why and nothing is created. The registration form and POST /api/register both use it,
+// so they accept exactly the same accounts.
+func registerUser(ctx context.Context, db *gorm.DB, cfg Config, username, email, password string) (*User, string, ValidationResult, error) {
+	// Usernames are stored trimmed and lowercased, so "Alice " and "alice" are one account
+	username = normalizeUsername(username)
+	validation := validateCredentials(username, password)
+	validation.Merge(validateEmail(email))
+	if !validation.Valid() {
+		return nil, "", validation, nil
+	}
+	email = normalizeEmail(email)
+
+	if isReservedUsername(username) {
+		validation.Add("username", "User already exists")
+		return nil, "", validation, nil
+	}
+
+	// Deleted accounts keep their name, so it can't be registered again
+	tx := db.WithContext(ctx)
+	var usernameCount int64
+	if err := tx.Unscoped().Model(&User{}).Where("username = ?", username).Count(&usernameCount).Error; err != nil {
+		return nil, "", validation, err
+	}
+	if usernameCount > 0 {
+		validation.Add("username", "User already exists")
+		return nil, "", validation, nil
+	}
+
+	var emailCount int64
+	if err := tx.Model(&User{}).Where("email = ?", email).Count(&emailCount).Error; err != nil {
+		return nil, "", validation, err
+	}
+	if emailCount > 0 {
+		validation.Add("email", "An account with that email already exists")
+		return nil, "", validation, nil
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, "", validation, err
+	}
+
+	// The email is verified with a link to the token, which only its hash is stored for
+	token, tokenHash := newVerificationToken()
+	user := User{Username: username, Email: &email, Password: string(hashedPassword), VerificationToken: tokenHash}
+	if err := createUser(ctx, db, &user); err != nil {
+		// Someone else registered the name or email since the checks above
+		if errors.Is(err, errUserExists) {
+			validation.Add("username", "User already exists")
+			return nil, "", validation, nil
+		}
+		if errors.Is(err, errEmailExists) {
+			validation.Add("email", "An account with that email already exists")
+			return nil, "", validation, nil
+		}
+		return nil, "", validation, err
+	}
+	return &user, token, validation, nil
+}
+
+// sendWelcomeEmail greets a new user by email, when WELCOME_EMAIL is on. It goes out alongside the
+// verification link, and the account exists either way, so failures are only logged.
+func sendWelcomeEmail(cfg Config, user *User) {
+	if !cfg.WelcomeEmail || user.Email == nil {
+		return
+	}
+	err := sendEmail(*user.Email, "Welcome to Go Fiber Template",
+		"Hi "+user.Username+",\n\nThanks for signing up! You can log in at:\n"+cfg.PublicURL+cfg.BasePath+"/login\n")
+	if err != nil {
+		slog.Error("error sending welcome email", "error", err)
+	}
+}
+
+// apiRegister answers POST /api/register: it creates an account from JSON, with the same rules
+// as the registration form, and returns it as public user JSON. It doesn't log in, so API
+// clients get no session or cookies; they ask /api/login for a token.
+func (h *Handlers) apiRegister(c *fiber.Ctx) error {
+	if h.cfg.ReadOnly {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": readOnlyMessage})
+	}
+
+	var data struct {
+		Username string `json:"username"`
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if err := c.BodyParser(&data); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+
+	user, token, validation, err := registerUser(c.UserContext(), h.db, h.cfg, data.Username, data.Email, data.Password)
+	if err != nil {
+		if isReadOnlyError(err) {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": readOnlyMessage})
+		}
+		return err
+	}
+	if !validation.Valid() {
+		body := validation.ToJSON()
+		body["error"] = "invalid registration"
+		return c.Status(fiber.StatusBadRequest).JSON(body)
+	}
+
+	if err := sendVerificationEmail(h.cfg, user, token); err != nil {
+		slog.Error("error sending verification email", "error", err)
+	}
+	sendWelcomeEmail(h.cfg, user)
+	return c.Status(fiber.StatusCreated).JSON(user.toPublic())
+}
+
+// deleteUser soft-deletes the user along with everything that only makes sense with the account:
+// recovery answers, session records and label assignments are removed, and the email is cleared
+// so it can be used to register again while the unique index still covers the deleted row. The
+// caller removes the profile picture file, since that can't be rolled back.
+func deleteUser(db *gorm.DB, user *User) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&SecurityAnswer{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&UserSession{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(user).Association("Labels").Clear(); err != nil {
+			return err
+		}
+		if err := tx.Model(user).Updates(map[string]any{"email": nil, "avatar_path": ""}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(user).Error
+	})
+}
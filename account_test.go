@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestCreateUserConcurrentDuplicates(t *testing.T) {
+	db := newTestDB(t)
+
+	const attempts = 2
+	errs := make([]error, attempts)
+	var start, done sync.WaitGroup
+	start.Add(1)
+	for i := range errs {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			start.Wait()
+			errs[i] = createUser(context.Background(), db, &User{Username: "alice", Password: "x"})
+		}()
+	}
+	start.Done()
+	done.Wait()
+
+	created := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, errUserExists):
+			t.Errorf("createUser: got error %v, want nil or errUserExists", err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d registrations succeeded, want 1", created)
+	}
+
+	var count int64
+	if err := db.Model(&User{}).Where("username = ?", "alice").Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("%d rows for alice, want 1", count)
+	}
+}
+
+func TestCreateUserReportsWhichFieldIsTaken(t *testing.T) {
+	db := newTestDB(t)
+	email := "alice@example.com"
+	if err := createUser(context.Background(), db, &User{Username: "alice", Email: &email, Password: "x"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		username, email string
+		want            error
+	}{
+		{"alice", "other@example.com", errUserExists},
+		{"bob", "alice@example.com", errEmailExists},
+	}
+	for _, tt := range tests {
+		err := createUser(context.Background(), db, &User{Username: tt.username, Email: &tt.email, Password: "x"})
+		if !errors.Is(err, tt.want) {
+			t.Errorf("createUser(%q, %q) = %v, want %v", tt.username, tt.email, err, tt.want)
+		}
+	}
+}
+
+func TestAPIRegister(t *testing.T) {
+	h := newTestHandlers(t, Config{})
+	app := fiber.New()
+	app.Post("/api/register", h.apiRegister)
+
+	register := func(body string) (int, map[string]any, []string) {
+		t.Helper()
+		req := httptest.NewRequest(fiber.MethodPost, "/api/register", strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded map[string]any
+		if err := json.Unmarshal(raw, &decoded); err != nil {
+			t.Fatalf("decoding %q: %v", raw, err)
+		}
+		return resp.StatusCode, decoded, resp.Header.Values(fiber.HeaderSetCookie)
+	}
+
+	status, body, cookies := register(`{"username": " Alice ", "email": "alice@example.com", "password": "secret123"}`)
+	if status != fiber.StatusCreated || body["Username"] != "alice" {
+		t.Fatalf("register = %d %v, want 201 with alice", status, body)
+	}
+	if len(cookies) != 0 {
+		t.Errorf("register set cookies %q", cookies)
+	}
+	if _, ok := body["Password"]; ok {
+		t.Error("response includes the password")
+	}
+
+	tests := []struct {
+		name, body, field string
+	}{
+		{"short password", `{"username": "bobby", "email": "bob@example.com", "password": "short1"}`, "password"},
+		{"bad email", `{"username": "bobby", "email": "bob", "password": "secret123"}`, "email"},
+		{"taken username", `{"username": "ALICE", "email": "bob@example.com", "password": "secret123"}`, "username"},
+		{"taken email", `{"username": "bobby", "email": "Alice@Example.com", "password": "secret123"}`, "email"},
+	}
+	for _, tt := range tests {
+		status, body, _ := register(tt.body)
+		errs, _ := body["errors"].(map[string]any)
+		if status != fiber.StatusBadRequest || body["error"] == nil || errs[tt.field] == nil {
+			t.Errorf("%s: got %d %v, want 400 with an error for %s", tt.name, status, body, tt.field)
+		}
+	}
+
+	var count int64
+	h.db.Model(&User{}).Count(&count)
+	if count != 1 {
+		t.Errorf("%d accounts created, want 1", count)
+	}
+}
+
+func TestRegisterUserReservedNames(t *testing.T) {
+	db := newTestDB(t)
+	cfg := Config{AdminUsername: "admin"}
+
+	// Reserved names can't be registered, not even the one ADMIN_USERNAME names
+	_, _, validation, err := registerUser(context.Background(), db, cfg, "root1", "r@example.com", "secret123")
+	if err != nil || !validation.Valid() {
+		t.Fatalf("registering root1: %v %v", validation.Errors, err)
+	}
+	for _, username := range []string{"Administrator", "Admin"} {
+		_, _, validation, err = registerUser(context.Background(), db, cfg, username, username+"@example.com", "secret123")
+		if err != nil || validation.Errors["username"] == "" {
+			t.Errorf("registering %s: got %v %v, want a username error", username, validation.Errors, err)
+		}
+	}
+}
+
+func TestRegisterUserDoesNotGrantAdmin(t *testing.T) {
+	db := newTestDB(t)
+	cfg := Config{AdminUsername: "jeremie"}
+
+	user, _, validation, err := registerUser(context.Background(), db, cfg, "jeremie", "j@example.com", "secret123")
+	if err != nil || !validation.Valid() {
+		t.Fatalf("registering ADMIN_USERNAME: %v %v", validation.Errors, err)
+	}
+	if user.Role == RoleAdmin {
+		t.Error("registering ADMIN_USERNAME gave it the admin role")
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// errInvalidCredentials is returned for both an unknown username and a wrong password, so
+// callers can't tell the two apart
+var errInvalidCredentials = errors.New("invalid username or password")
+
+// errAccountLocked is returned while an account is locked after too many wrong passwords,
+// whether or not the password given is right
+var errAccountLocked = errors.New("account temporarily locked")
+
+// An account is locked for lockoutDuration after lockoutThreshold wrong passwords in a row.
+// Unlike the per-IP login limiter, this also slows attacks spread over many addresses.
+const (
+	lockoutThreshold = 5
+	lockoutDuration  = 15 * time.Minute
+)
+
+// dummyPasswordHash is compared against when the username doesn't exist, so that path costs
+// as much as a wrong password and response times don't reveal which accounts exist. It's made
+// at startup with the same cost as real hashes, so the first unknown user isn't slower either.
+var dummyPasswordHash = func() []byte {
+	hash, err := bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+	if err != nil {
+		panic("generating dummy password hash: " + err.Error())
+	}
+	return hash
+}()
+
+// authenticate returns the user with the given username and password, or errInvalidCredentials.
+// The username matches regardless of case and surrounding spaces. Wrong passwords count
+// towards locking the account, and while it's locked errAccountLocked is returned instead.
+func authenticate(ctx context.Context, db *gorm.DB, username, password string) (*User, error) {
+	tx := db.WithContext(ctx)
+	var user User
+	if err := tx.Where("username = ?", normalizeUsername(username)).Limit(1).Find(&user).Error; err != nil {
+		return nil, err
+	}
+	if user.ID == 0 {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return nil, errInvalidCredentials
+	}
+	// The password is checked even while the account is locked, so a locked account takes as
+	// long to answer as any other and the timing doesn't reveal that it exists
+	passwordErr := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		return nil, errAccountLocked
+	}
+	if passwordErr != nil {
+		return nil, recordFailedLogin(tx, &user)
+	}
+
+	// Column updates, like recordLogin, so they don't move UpdatedAt
+	if user.FailedLogins > 0 || user.LockedUntil != nil {
+		err := tx.Model(&user).UpdateColumns(map[string]any{"failed_logins": 0, "locked_until": nil}).Error
+		if err != nil && !isReadOnlyError(err) {
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+// recordFailedLogin counts a wrong password for user and locks the account once there have
+// been lockoutThreshold in a row. It returns the error authenticate should: errAccountLocked if
+// this attempt locked it, errInvalidCredentials otherwise. A read-only database can't count.
+func recordFailedLogin(tx *gorm.DB, user *User) error {
+	// Counted in the database, so concurrent attempts can't overwrite each other's count
+	err := tx.Model(user).UpdateColumn("failed_logins", gorm.Expr("failed_logins + 1")).Error
+	if err != nil {
+		if isReadOnlyError(err) {
+			return errInvalidCredentials
+		}
+		return err
+	}
+	lock := tx.Model(&User{}).Where("id = ? AND failed_logins >= ?", user.ID, lockoutThreshold).
+		UpdateColumns(map[string]any{"failed_logins": 0, "locked_until": time.Now().Add(lockoutDuration)})
+	if lock.Error != nil {
+		return lock.Error
+	}
+	if lock.RowsAffected > 0 {
+		return errAccountLocked
+	}
+	return errInvalidCredentials
+}
+
+// recordLogin sets the user's LastLoginAt to now. It's written as a column update so logins
+// don't move UpdatedAt, which versions the users listing.
+func recordLogin(ctx context.Context, db *gorm.DB, user *User) error {
+	now := time.Now()
+	if err := db.WithContext(ctx).Model(user).UpdateColumn("last_login_at", now).Error; err != nil {
+		return err
+	}
+	user.LastLoginAt = &now
+	return nil
+}
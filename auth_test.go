@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB returns a migrated database in a temporary directory
+func newTestDB(t testing.TB) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger:         logger.Default.LogMode(logger.Silent),
+		TranslateError: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+// createTestUser stores a user with the given password, hashed at the minimum cost to keep tests fast
+func createTestUser(t *testing.T, db *gorm.DB, username, password string) User {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := User{Username: username, Password: string(hash)}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestAuthenticate(t *testing.T) {
+	db := newTestDB(t)
+	created := createTestUser(t, db, "alice", "correct horse")
+
+	user, err := authenticate(context.Background(), db, "alice", "correct horse")
+	if err != nil {
+		t.Fatalf("authenticate with the right password: %v", err)
+	}
+	if user.ID != created.ID {
+		t.Errorf("authenticate returned user %d, want %d", user.ID, created.ID)
+	}
+}
+
+func TestAuthenticateSameErrorForUnknownUserAndWrongPassword(t *testing.T) {
+	db := newTestDB(t)
+	createTestUser(t, db, "alice", "correct horse")
+
+	_, wrongPassword := authenticate(context.Background(), db, "alice", "battery staple")
+	_, unknownUser := authenticate(context.Background(), db, "mallory", "battery staple")
+
+	for name, err := range map[string]error{"wrong password": wrongPassword, "unknown user": unknownUser} {
+		if !errors.Is(err, errInvalidCredentials) {
+			t.Errorf("%s: got error %v, want errInvalidCredentials", name, err)
+		}
+	}
+	if wrongPassword.Error() != unknownUser.Error() {
+		t.Errorf("error messages differ: %q and %q", wrongPassword, unknownUser)
+	}
+}
+
+func TestAuthenticateIgnoresUsernameCaseAndSpaces(t *testing.T) {
+	db := newTestDB(t)
+	created := createTestUser(t, db, "alice", "correct horse")
+
+	user, err := authenticate(context.Background(), db, "  Alice ", "correct horse")
+	if err != nil {
+		t.Fatalf("authenticate with a differently cased username: %v", err)
+	}
+	if user.ID != created.ID {
+		t.Errorf("authenticate returned user %d, want %d", user.ID, created.ID)
+	}
+}
+
+func TestMigrateNormalizesUsernames(t *testing.T) {
+	db := newTestDB(t)
+	// Stored before usernames were normalized
+	created := createTestUser(t, db, " Alice", "correct horse")
+	if err := migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	var user User
+	if err := db.First(&user, created.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != "alice" {
+		t.Errorf("username after migrating = %q, want %q", user.Username, "alice")
+	}
+
+	createTestUser(t, db, "Carol", "correct horse")
+	createTestUser(t, db, "carol", "correct horse")
+	if err := migrate(db); err == nil || !strings.Contains(err.Error(), `"Carol" and "carol"`) {
+		t.Errorf("migrate with colliding usernames = %v, want an error naming both", err)
+	}
+}
+
+func TestAuthenticateLocksAfterRepeatedFailures(t *testing.T) {
+	db := newTestDB(t)
+	created := createTestUser(t, db, "alice", "correct horse")
+	ctx := context.Background()
+
+	// Attempts count however the username is typed
+	for i := 1; i < lockoutThreshold; i++ {
+		if _, err := authenticate(ctx, db, "Alice", "battery staple"); !errors.Is(err, errInvalidCredentials) {
+			t.Fatalf("wrong password %d: got %v, want errInvalidCredentials", i, err)
+		}
+	}
+	if _, err := authenticate(ctx, db, "alice ", "battery staple"); !errors.Is(err, errAccountLocked) {
+		t.Fatalf("wrong password %d: got %v, want errAccountLocked", lockoutThreshold, err)
+	}
+	if _, err := authenticate(ctx, db, "alice", "correct horse"); !errors.Is(err, errAccountLocked) {
+		t.Fatalf("right password while locked: got %v, want errAccountLocked", err)
+	}
+
+	// Once the lock runs out, the right password works and starts the count again
+	if err := db.Model(&User{}).Where("id = ?", created.ID).Update("locked_until", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := authenticate(ctx, db, "alice", "correct horse"); err != nil {
+		t.Fatalf("right password after the lock: %v", err)
+	}
+	var user User
+	if err := db.First(&user, created.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if user.FailedLogins != 0 || user.LockedUntil != nil {
+		t.Errorf("after logging in FailedLogins = %d, LockedUntil = %v, want both reset", user.FailedLogins, user.LockedUntil)
+	}
+}
+
+func TestAuthenticateChecksPasswordWhileLocked(t *testing.T) {
+	db := newTestDB(t)
+	// A real cost, so a skipped comparison shows in the timing
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockedUntil := time.Now().Add(time.Hour)
+	user := User{Username: "alice", Password: string(hash), LockedUntil: &lockedUntil}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	bcrypt.CompareHashAndPassword(hash, []byte("battery staple"))
+	comparison := time.Since(start)
+
+	start = time.Now()
+	if _, err := authenticate(context.Background(), db, "alice", "battery staple"); !errors.Is(err, errAccountLocked) {
+		t.Fatalf("got %v, want errAccountLocked", err)
+	}
+	if elapsed := time.Since(start); elapsed < comparison/2 {
+		t.Errorf("locked account answered in %v, a password comparison takes %v", elapsed, comparison)
+	}
+}
+
+func TestAuthenticateSuccessResetsFailedLogins(t *testing.T) {
+	db := newTestDB(t)
+	createTestUser(t, db, "alice", "correct horse")
+	ctx := context.Background()
+
+	// Wrong passwords only lock the account when they come in a row
+	for round := 0; round < 2; round++ {
+		for i := 1; i < lockoutThreshold; i++ {
+			authenticate(ctx, db, "alice", "battery staple")
+		}
+		if _, err := authenticate(ctx, db, "alice", "correct horse"); err != nil {
+			t.Fatalf("round %d: right password: %v", round, err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// maxAvatarSize is the largest profile picture accepted, in bytes
+	maxAvatarSize = 2 << 20
+	// avatarDir holds uploaded profile pictures, relative to STATIC_DIR
+	avatarDir = "avatars"
+)
+
+// avatarTypes maps the image types accepted as profile pictures to the extension they're saved with
+var avatarTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+}
+
+// Messages for rejected uploads, fit to show the user
+var (
+	errAvatarSize = errors.New("Profile pictures must be 2MB or smaller")
+	errAvatarType = errors.New("Profile pictures must be JPEG or PNG images")
+)
+
+// readAvatar reads an uploaded profile picture and returns it with the extension to save it
+// under. The type is sniffed from the content, since the Content-Type is whatever the client says.
+func readAvatar(c *fiber.Ctx) ([]byte, string, error) {
+	header, err := c.FormFile("avatar")
+	if err != nil {
+		return nil, "", errors.New("Please choose a picture to upload")
+	}
+	if header.Size > maxAvatarSize {
+		return nil, "", errAvatarSize
+	}
+	file, err := header.Open()
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxAvatarSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > maxAvatarSize {
+		return nil, "", errAvatarSize
+	}
+	ext, ok := avatarTypes[http.DetectContentType(data)]
+	if !ok {
+		return nil, "", errAvatarType
+	}
+	return data, ext, nil
+}
+
+// uploadAvatar answers POST /profile/avatar, replacing the user's profile picture. Rejected
+// uploads are flashed and leave the account untouched.
+func (h *Handlers) uploadAvatar(c *fiber.Ctx) error {
+	user := currentUser(c)
+
+	if h.cfg.ReadOnly {
+		h.flash.Add(c, readOnlyMessage, "warning")
+		return redirect(c, "/profile")
+	}
+
+	data, ext, err := readAvatar(c)
+	if err != nil {
+		h.flash.Add(c, err.Error(), "danger")
+		return redirect(c, "/profile")
+	}
+
+	// Each upload gets a new name, so the current picture stays in place until the account
+	// points at its replacement, and browsers don't keep showing a cached old one
+	path := avatarDir + "/" + strconv.FormatUint(uint64(user.ID), 10) + "-" + secureToken(minTokenBytes) + ext
+	if err := os.MkdirAll(filepath.Join(h.cfg.StaticDir, avatarDir), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(h.cfg.StaticDir, path), data, 0o644); err != nil {
+		return err
+	}
+
+	previous := user.AvatarPath
+	if err := h.db.WithContext(c.UserContext()).Model(user).Update("avatar_path", path).Error; err != nil {
+		// Nothing points at the new file
+		removeAvatar(h.cfg, path)
+		if isReadOnlyError(err) {
+			h.flash.Add(c, readOnlyMessage, "warning")
+			return redirect(c, "/profile")
+		}
+		return err
+	}
+	removeAvatar(h.cfg, previous)
+
+	h.flash.Add(c, "Profile picture updated", "success")
+	return redirect(c, "/profile")
+}
+
+// removeAvatar deletes a saved profile picture, if there is one. Failures are only logged, since
+// no account points at the file any more.
+func removeAvatar(cfg Config, path string) {
+	if path == "" {
+		return
+	}
+	if err := os.Remove(filepath.Join(cfg.StaticDir, path)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("could not remove profile picture", "path", path, "error", err)
+	}
+}
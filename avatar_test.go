@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// postAvatar returns a multipart POST to /profile/avatar uploading data as the avatar file
+func postAvatar(t *testing.T, data []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("avatar", "avatar.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	form.Close()
+	req := httptest.NewRequest(fiber.MethodPost, "/profile/avatar", &body)
+	req.Header.Set(fiber.HeaderContentType, form.FormDataContentType())
+	return req
+}
+
+func TestUploadAvatar(t *testing.T) {
+	staticDir := t.TempDir()
+	h := newTestHandlers(t, Config{StaticDir: staticDir})
+	alice := createTestUser(t, h.db, "alice", "secret123")
+	app := newTestApp(&alice)
+	app.Post("/profile/avatar", h.uploadAvatar)
+
+	storedPath := func() string {
+		var user User
+		if err := h.db.First(&user, alice.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		return user.AvatarPath
+	}
+
+	rejected := map[string][]byte{
+		"wrong type": []byte("GIF89a not really a picture"),
+		"too large":  append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, maxAvatarSize)...),
+	}
+	for name, data := range rejected {
+		resp := doRequest(t, app, postAvatar(t, data))
+		if resp.StatusCode != fiber.StatusFound {
+			t.Errorf("%s: answered %d, want a redirect back to the profile", name, resp.StatusCode)
+		}
+		if path := storedPath(); path != "" {
+			t.Errorf("%s: stored avatar %q", name, path)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(staticDir, avatarDir)); err == nil {
+		t.Error("rejected uploads were written to disk")
+	}
+
+	var picture bytes.Buffer
+	if err := png.Encode(&picture, image.NewRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	doRequest(t, app, postAvatar(t, picture.Bytes()))
+	path := storedPath()
+	if matched, _ := filepath.Match("avatars/1-*.png", path); !matched {
+		t.Fatalf("stored avatar %q, want avatars/1-<random>.png", path)
+	}
+	saved, err := os.ReadFile(filepath.Join(staticDir, path))
+	if err != nil || !bytes.Equal(saved, picture.Bytes()) {
+		t.Errorf("saved picture doesn't match the upload: %v", err)
+	}
+
+	// A new picture replaces the old file once the account points at it
+	doRequest(t, app, postAvatar(t, picture.Bytes()))
+	replacement := storedPath()
+	if replacement == path {
+		t.Fatal("a new upload kept the old name")
+	}
+	if avatars := avatarFiles(t, staticDir); len(avatars) != 1 || avatars[0] != filepath.Base(replacement) {
+		t.Errorf("avatars on disk after replacing = %q, want only %q", avatars, replacement)
+	}
+
+	// An upload the account can't be pointed at doesn't leave its file behind
+	sqlDB, err := h.db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.Close()
+	doRequest(t, app, postAvatar(t, picture.Bytes()))
+	if avatars := avatarFiles(t, staticDir); len(avatars) != 1 || avatars[0] != filepath.Base(replacement) {
+		t.Errorf("avatars on disk after a failed update = %q, want only %q", avatars, replacement)
+	}
+}
+
+// avatarFiles lists the files in staticDir's avatar folder
+func avatarFiles(t *testing.T, staticDir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Join(staticDir, avatarDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
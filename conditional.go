@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// notModified sets the ETag and Last-Modified headers and reports whether the client's copy,
+// named by If-None-Match or If-Modified-Since, is still current. If-None-Match wins when both
+// are sent, as RFC 9110 requires.
+func notModified(c *fiber.Ctx, etag string, lastModified time.Time) bool {
+	c.Set(fiber.HeaderETag, etag)
+	if !lastModified.IsZero() {
+		c.Set(fiber.HeaderLastModified, lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if match := c.Get(fiber.HeaderIfNoneMatch); match != "" {
+		for _, tag := range strings.Split(match, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	if since, err := http.ParseTime(c.Get(fiber.HeaderIfModifiedSince)); err == nil && !lastModified.IsZero() {
+		// HTTP dates have second precision
+		return !lastModified.Truncate(time.Second).After(since)
+	}
+	return false
+}
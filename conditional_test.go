@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestNotModified(t *testing.T) {
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name          string
+		header, value string
+		lastModified  time.Time
+		want          bool
+	}{
+		{"no validators", "", "", modified, false},
+		{"matching ETag", fiber.HeaderIfNoneMatch, `W/"2-1"`, modified, true},
+		{"strong form of the weak ETag", fiber.HeaderIfNoneMatch, `"2-1"`, modified, true},
+		{"one of several ETags", fiber.HeaderIfNoneMatch, `"other", W/"2-1"`, modified, true},
+		{"any ETag", fiber.HeaderIfNoneMatch, "*", modified, true},
+		{"stale ETag", fiber.HeaderIfNoneMatch, `W/"1-1"`, modified, false},
+		{"since the change", fiber.HeaderIfModifiedSince, modified.Format(http.TimeFormat), modified, true},
+		{"before the change", fiber.HeaderIfModifiedSince, modified.Add(-time.Second).Format(http.TimeFormat), modified, false},
+		{"unparseable date", fiber.HeaderIfModifiedSince, "yesterday", modified, false},
+		{"no modification time", fiber.HeaderIfModifiedSince, modified.Format(http.TimeFormat), time.Time{}, false},
+	}
+	for _, tt := range tests {
+		var got bool
+		var lastModifiedHeader string
+		app := fiber.New()
+		app.Get("/", func(c *fiber.Ctx) error {
+			got = notModified(c, `W/"2-1"`, tt.lastModified)
+			lastModifiedHeader = string(c.Response().Header.Peek(fiber.HeaderLastModified))
+			return nil
+		})
+		req := httptest.NewRequest(fiber.MethodGet, "/", nil)
+		if tt.header != "" {
+			req.Header.Set(tt.header, tt.value)
+		}
+		if _, err := app.Test(req); err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: notModified = %v, want %v", tt.name, got, tt.want)
+		}
+		if tt.lastModified.IsZero() != (lastModifiedHeader == "") {
+			t.Errorf("%s: Last-Modified = %q for modification time %v", tt.name, lastModifiedHeader, tt.lastModified)
+		}
+	}
+}
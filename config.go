@@ -0,0 +1,375 @@
+package main
+
+import (
+	"log"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2/middleware/compress"
+)
+
+// compressLevels maps COMPRESS_LEVEL values to the compress middleware's levels
+var compressLevels = map[string]compress.Level{
+	"off":              compress.LevelDisabled,
+	"best-speed":       compress.LevelBestSpeed,
+	"default":          compress.LevelDefault,
+	"best-compression": compress.LevelBestCompression,
+}
+
+// minSessionSecretLength is the shortest SESSION_SECRET and JWT_SECRET accepted in production
+const minSessionSecretLength = 32
+
+// Config holds the settings read from the environment at startup
+type Config struct {
+	// AppEnv is the deployment environment; "production" enables stricter checks
+	AppEnv string
+
+	// LogFormat is the access log format, "text" (default) or "json"
+	LogFormat string
+	// LogLevel is the least severe message logged, see logLevels; per-request detail is debug
+	LogLevel slog.Level
+	// CompressLevel is how hard responses are gzip/brotli compressed, see compressLevels
+	CompressLevel compress.Level
+
+	// Port is what the HTTP server listens on
+	Port string
+	// BasePath is the sub-path a reverse proxy serves the app under, like "/myapp", without a
+	// trailing slash; empty at the root
+	BasePath string
+	// PublicURL is the scheme and host users reach the app at, like "https://example.com",
+	// which emailed links start with. The request's Host header can't be trusted for those.
+	PublicURL string
+	// DBDriver is the database to use: "sqlite" (default), "postgres" or "mysql"
+	DBDriver string
+	// DBDSN is the connection string for DBDriver; SQLite falls back to DBPath when it's empty
+	DBDSN string `debug:"redact"`
+	// DBPath is the SQLite database file
+	DBPath string
+	// StaticDir is served under /static, TemplateDir holds the HTML templates
+	StaticDir   string
+	TemplateDir string
+
+	// AdminUsername is given the admin role at startup, if that account exists
+	AdminUsername string
+
+	// EnableUsersAPI exposes GET /api/users to admins, which lists every account, and GET /api/users/:id
+	EnableUsersAPI bool
+	// UsersAPIMaxRows caps how far into the users listing pages go, 0 means no cap
+	UsersAPIMaxRows int
+	// UsersAPIQuota is how many listings each user may request per UsersAPIQuotaWindow, 0 disables it
+	UsersAPIQuota       int
+	UsersAPIQuotaWindow time.Duration
+
+	// CORSOrigins are the other origins whose browser front-ends may call /api; none means
+	// same-origin only
+	CORSOrigins []string
+
+	// TrustedProxies are the IPs or CIDR ranges whose X-Forwarded-* headers are believed
+	TrustedProxies []string
+	// ForceHTTPS redirects plain HTTP requests to HTTPS, except for ForceHTTPSExempt paths
+	ForceHTTPS       bool
+	ForceHTTPSExempt []string
+
+	// SuspiciousRequestAction is what happens to requests that look malicious: "off", "log"
+	// (default) or "block"
+	SuspiciousRequestAction string
+	SuspiciousUserAgents    []string
+	SuspiciousPathPatterns  []string
+	SuspiciousHeaderLength  int
+
+	// ReadOnly refuses writes up front with a maintenance message, e.g. during a planned failover
+	ReadOnly bool
+
+	// FormNonces rejects forms that are submitted twice, using a one-time nonce per render
+	FormNonces bool
+
+	// SecurityQuestions enables account recovery by answering security questions at /recover
+	SecurityQuestions bool
+
+	// WelcomeEmail mails new users a welcome message after registration
+	WelcomeEmail bool
+	// WelcomeFlash shows a welcome message after registration
+	WelcomeFlash bool
+	// WelcomeRedirect is where new users are sent after registering
+	WelcomeRedirect string
+
+	// SessionSecret signs cookies such as the flash cookie and, when set, encrypts the session cookie
+	SessionSecret string `debug:"redact"`
+	// JWTSecret signs the API tokens issued by /api/login, JWTTTL is how long they last
+	JWTSecret string `debug:"redact"`
+	JWTTTL    time.Duration
+	// FlashStorage is where flash messages are kept: "session" (default), "cookie" for a signed
+	// cookie, or "fallback" to use the cookie only when the session is unavailable
+	FlashStorage string
+	// HTMXFlashes delivers flashes to HTMX partial requests in an HX-Trigger header instead of the page
+	HTMXFlashes bool
+
+	// SessionStorage selects where sessions live: "memory" (default) or "sql"
+	SessionStorage string
+	// SessionHashIDs stores session IDs hashed in database tables rather than in the clear
+	SessionHashIDs bool
+	// SessionBinding ties logged-in sessions to the device they were created on: "off" (default),
+	// "lenient" (tolerates browser version changes) or "strict" (exact user agent)
+	SessionBinding string
+	// SessionValidationURL is an external service asked whether sessions are still valid, empty disables it
+	SessionValidationURL      string `debug:"url"`
+	SessionValidationTimeout  time.Duration
+	SessionValidationCacheTTL time.Duration
+	// SessionValidationFailOpen keeps sessions working while the validation service is unreachable
+	SessionValidationFailOpen bool
+	// SessionEventLog logs a structured line whenever a logged-in session starts or ends
+	SessionEventLog bool
+	// DedupeUserLookups makes concurrent requests for the same logged-in user share one query
+	DedupeUserLookups bool
+	// SessionCleanupInterval is how often expired sessions are purged from the sql storage
+	SessionCleanupInterval time.Duration
+	// SessionIdleTimeout ends a login without "remember me" after that long without a request.
+	// Each request pushes it back, but never past SessionMaxLifetime from the login.
+	SessionIdleTimeout time.Duration
+	SessionMaxLifetime time.Duration
+
+	// APIDocs serves the OpenAPI document at /api/v1/openapi.json and Swagger UI at /api/docs
+	APIDocs bool
+
+	// APIRateTiers is the number of API requests allowed per APIRateWindow for each rate tier
+	APIRateTiers  map[string]int
+	APIRateWindow time.Duration
+
+	// SlowRequestThreshold is the latency above which a request gets a warning log, 0 disables it
+	SlowRequestThreshold time.Duration
+
+	// SQLiteMaintenanceInterval is how often VACUUM runs on SQLite databases, 0 disables it
+	SQLiteMaintenanceInterval time.Duration
+	// SQLiteMaintenanceWindow limits maintenance to low-traffic hours
+	SQLiteMaintenanceWindow maintenanceWindow
+
+	// PrewarmTemplates parses every template at startup and refuses to start if one is broken
+	PrewarmTemplates bool
+
+	// DebugPprof exposes the net/http/pprof handlers under /admin/debug/pprof for admins
+	DebugPprof bool
+
+	// How long each subsystem gets to stop during graceful shutdown
+	ShutdownHTTPTimeout     time.Duration
+	ShutdownSessionsTimeout time.Duration
+	ShutdownDBTimeout       time.Duration
+}
+
+func loadConfig() Config {
+	rateTiers, err := parseRateTiers(envOr("API_RATE_TIERS", "anonymous=30,free=60,premium=600"))
+	if err != nil {
+		log.Fatalf("invalid API_RATE_TIERS: %v", err)
+	}
+
+	welcomeRedirect := envOr("WELCOME_REDIRECT", "/")
+	// Only local paths, so a bad value can't turn registration into an open redirect
+	if !isLocalPath(welcomeRedirect) {
+		log.Fatalf("invalid WELCOME_REDIRECT %q, must be a path starting with /", welcomeRedirect)
+	}
+
+	maintenanceWindow, err := parseMaintenanceWindow(os.Getenv("SQLITE_MAINTENANCE_WINDOW"))
+	if err != nil {
+		log.Fatalf("invalid SQLITE_MAINTENANCE_WINDOW: %v", err)
+	}
+
+	suspiciousAction := envOr("SUSPICIOUS_REQUEST_ACTION", suspiciousLog)
+	switch suspiciousAction {
+	case suspiciousOff, suspiciousLog, suspiciousBlock:
+	default:
+		log.Fatalf("invalid SUSPICIOUS_REQUEST_ACTION %q, must be off, log or block", suspiciousAction)
+	}
+
+	flashStorage := envOr("FLASH_STORAGE", flashStorageSession)
+	switch flashStorage {
+	case flashStorageSession, flashStorageCookie, flashStorageFallback:
+	default:
+		log.Fatalf("invalid FLASH_STORAGE %q, must be session, cookie or fallback", flashStorage)
+	}
+
+	sessionBinding := envOr("SESSION_BINDING", sessionBindingOff)
+	switch sessionBinding {
+	case sessionBindingOff, sessionBindingLenient, sessionBindingStrict:
+	default:
+		log.Fatalf("invalid SESSION_BINDING %q, must be off, lenient or strict", sessionBinding)
+	}
+
+	dbDriver := envOr("DB_DRIVER", dbDriverSQLite)
+	dbDSN := os.Getenv("DB_DSN")
+	switch dbDriver {
+	case dbDriverSQLite:
+	case dbDriverPostgres, dbDriverMySQL:
+		if dbDSN == "" {
+			log.Fatalf("DB_DSN is required when DB_DRIVER=%s", dbDriver)
+		}
+	default:
+		log.Fatalf("invalid DB_DRIVER %q, must be sqlite, postgres or mysql", dbDriver)
+	}
+
+	logFormat := envOr("LOG_FORMAT", logFormatText)
+	switch logFormat {
+	case logFormatText, logFormatJSON:
+	default:
+		log.Fatalf("invalid LOG_FORMAT %q, must be text or json", logFormat)
+	}
+
+	logLevel, ok := logLevels[envOr("LOG_LEVEL", "info")]
+	if !ok {
+		log.Fatalf("invalid LOG_LEVEL %q, must be debug, info, warn or error", os.Getenv("LOG_LEVEL"))
+	}
+
+	compressLevel, ok := compressLevels[envOr("COMPRESS_LEVEL", "best-speed")]
+	if !ok {
+		log.Fatalf("invalid COMPRESS_LEVEL %q, must be off, best-speed, default or best-compression", os.Getenv("COMPRESS_LEVEL"))
+	}
+
+	basePath := strings.TrimRight(os.Getenv("BASE_PATH"), "/")
+	if basePath != "" && (!isLocalPath(basePath) || strings.ContainsAny(basePath, "?#")) {
+		log.Fatalf("invalid BASE_PATH %q, must be a path starting with /", os.Getenv("BASE_PATH"))
+	}
+
+	sessionIdleTimeout := time.Duration(envInt("SESSION_IDLE_MINUTES", 60)) * time.Minute
+	if sessionIdleTimeout <= 0 {
+		log.Fatalf("invalid SESSION_IDLE_MINUTES %q, must be a positive number of minutes", os.Getenv("SESSION_IDLE_MINUTES"))
+	}
+
+	appEnv := envOr("APP_ENV", "development")
+	sessionSecret := os.Getenv("SESSION_SECRET")
+	// A missing secret means a random or guessable key, which production must not run with
+	if appEnv == "production" && len(sessionSecret) < minSessionSecretLength {
+		log.Fatalf("SESSION_SECRET must be at least %d characters when APP_ENV=production", minSessionSecretLength)
+	}
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if appEnv == "production" && len(jwtSecret) < minSessionSecretLength {
+		log.Fatalf("JWT_SECRET must be at least %d characters when APP_ENV=production", minSessionSecretLength)
+	}
+
+	port := envOr("PORT", "3000")
+	publicURL := strings.TrimRight(os.Getenv("PUBLIC_URL"), "/")
+	if publicURL == "" {
+		// Emails go out in production, and a guessed host would send users elsewhere
+		if appEnv == "production" {
+			log.Fatalf("PUBLIC_URL is required when APP_ENV=production, emailed links are built from it")
+		}
+		publicURL = "http://localhost:" + port
+	}
+	if u, err := url.Parse(publicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" || u.Fragment != "" {
+		log.Fatalf("invalid PUBLIC_URL %q, must be a scheme and host like https://example.com; use BASE_PATH for a sub-path", os.Getenv("PUBLIC_URL"))
+	}
+
+	return Config{
+		AppEnv:                    appEnv,
+		LogFormat:                 logFormat,
+		LogLevel:                  logLevel,
+		CompressLevel:             compressLevel,
+		Port:                      port,
+		BasePath:                  basePath,
+		PublicURL:                 publicURL,
+		DBDriver:                  dbDriver,
+		DBDSN:                     dbDSN,
+		DBPath:                    envOr("DB_PATH", "site.db"),
+		StaticDir:                 envOr("STATIC_DIR", "./static"),
+		TemplateDir:               envOr("TEMPLATE_DIR", "./templates"),
+		AdminUsername:             normalizeUsername(os.Getenv("ADMIN_USERNAME")),
+		EnableUsersAPI:            envBool("ENABLE_USERS_API", false),
+		UsersAPIMaxRows:           envInt("USERS_API_MAX_ROWS", 1000),
+		UsersAPIQuota:             envInt("USERS_API_QUOTA", 30),
+		UsersAPIQuotaWindow:       envDuration("USERS_API_QUOTA_WINDOW", time.Hour),
+		CORSOrigins:               envList("CORS_ORIGINS", nil),
+		TrustedProxies:            envList("TRUSTED_PROXIES", nil),
+		ForceHTTPS:                envBool("FORCE_HTTPS", false),
+		ForceHTTPSExempt:          envList("FORCE_HTTPS_EXEMPT", []string{"/health"}),
+		SuspiciousRequestAction:   suspiciousAction,
+		SuspiciousUserAgents:      envList("SUSPICIOUS_USER_AGENTS", []string{"sqlmap", "nikto", "nmap", "masscan", "zgrab", "dirbuster"}),
+		SuspiciousPathPatterns:    envList("SUSPICIOUS_PATH_PATTERNS", []string{"../", "..%2f", "<script", "%3cscript", "/etc/passwd", "/.env", "/.git/", "wp-admin"}),
+		SuspiciousHeaderLength:    envInt("SUSPICIOUS_HEADER_LENGTH", 2048),
+		ReadOnly:                  envBool("READ_ONLY", false),
+		FormNonces:                envBool("FORM_NONCES", true),
+		SecurityQuestions:         envBool("SECURITY_QUESTIONS", false),
+		WelcomeEmail:              envBool("WELCOME_EMAIL", true),
+		WelcomeFlash:              envBool("WELCOME_FLASH", true),
+		WelcomeRedirect:           welcomeRedirect,
+		SessionSecret:             sessionSecret,
+		JWTSecret:                 jwtSecret,
+		JWTTTL:                    envDuration("JWT_TTL", time.Hour),
+		FlashStorage:              flashStorage,
+		HTMXFlashes:               envBool("HTMX_FLASHES", true),
+		SessionStorage:            envOr("SESSION_STORAGE", "memory"),
+		SessionHashIDs:            envBool("SESSION_HASH_IDS", true),
+		SessionBinding:            sessionBinding,
+		SessionValidationURL:      os.Getenv("SESSION_VALIDATION_URL"),
+		SessionValidationTimeout:  envDuration("SESSION_VALIDATION_TIMEOUT", 2*time.Second),
+		SessionValidationCacheTTL: envDuration("SESSION_VALIDATION_CACHE_TTL", time.Minute),
+		SessionValidationFailOpen: envBool("SESSION_VALIDATION_FAIL_OPEN", false),
+		SessionEventLog:           envBool("SESSION_EVENT_LOG", true),
+		DedupeUserLookups:         envBool("DEDUPE_USER_LOOKUPS", true),
+		SessionCleanupInterval:    envDuration("SESSION_CLEANUP_INTERVAL", 10*time.Minute),
+		SessionIdleTimeout:        sessionIdleTimeout,
+		SessionMaxLifetime:        envDuration("SESSION_MAX_LIFETIME", 24*time.Hour),
+		APIDocs:                   envBool("API_DOCS", true),
+		APIRateTiers:              rateTiers,
+		APIRateWindow:             envDuration("API_RATE_WINDOW", time.Minute),
+		SQLiteMaintenanceInterval: envDuration("SQLITE_MAINTENANCE_INTERVAL", 0),
+		SQLiteMaintenanceWindow:   maintenanceWindow,
+		PrewarmTemplates:          envBool("PREWARM_TEMPLATES", false),
+		DebugPprof:                envBool("DEBUG_PPROF", false),
+		SlowRequestThreshold:      envDuration("SLOW_REQUEST_THRESHOLD", 500*time.Millisecond),
+
+		ShutdownHTTPTimeout:     envDuration("SHUTDOWN_HTTP_TIMEOUT", 10*time.Second),
+		ShutdownSessionsTimeout: envDuration("SHUTDOWN_SESSIONS_TIMEOUT", 5*time.Second),
+		ShutdownDBTimeout:       envDuration("SHUTDOWN_DB_TIMEOUT", 5*time.Second),
+	}
+}
+
+// envOr returns the value of the environment variable key, or fallback if it is unset or empty
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// envBool parses the environment variable key as a bool, returning fallback if it is unset or invalid
+func envBool(key string, fallback bool) bool {
+	b, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return b
+}
+
+// envInt parses the environment variable key as an int, returning fallback if it is unset or invalid
+func envInt(key string, fallback int) int {
+	n, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return n
+}
+
+// envList splits the comma-separated environment variable key, returning fallback if it is unset or empty
+func envList(key string, fallback []string) []string {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// envDuration parses the environment variable key with time.ParseDuration, returning fallback if it is unset or invalid
+func envDuration(key string, fallback time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return d
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEnvOr(t *testing.T) {
+	const key = "FIBER_TEMPLATE_TEST_ENV_OR"
+
+	t.Run("unset", func(t *testing.T) {
+		if got := envOr(key, "fallback"); got != "fallback" {
+			t.Errorf("envOr = %q, want %q", got, "fallback")
+		}
+	})
+	t.Run("empty", func(t *testing.T) {
+		t.Setenv(key, "")
+		if got := envOr(key, "fallback"); got != "fallback" {
+			t.Errorf("envOr = %q, want %q", got, "fallback")
+		}
+	})
+	t.Run("set", func(t *testing.T) {
+		t.Setenv(key, "8080")
+		if got := envOr(key, "fallback"); got != "8080" {
+			t.Errorf("envOr = %q, want %q", got, "8080")
+		}
+	})
+}
+
+func TestEnvParsersFallBackOnInvalidValues(t *testing.T) {
+	t.Setenv("FIBER_TEMPLATE_TEST_BOOL", "maybe")
+	t.Setenv("FIBER_TEMPLATE_TEST_INT", "ten")
+	t.Setenv("FIBER_TEMPLATE_TEST_DURATION", "soon")
+
+	if got := envBool("FIBER_TEMPLATE_TEST_BOOL", true); got != true {
+		t.Errorf("envBool = %v, want fallback true", got)
+	}
+	if got := envInt("FIBER_TEMPLATE_TEST_INT", 7); got != 7 {
+		t.Errorf("envInt = %d, want fallback 7", got)
+	}
+	if got := envDuration("FIBER_TEMPLATE_TEST_DURATION", time.Minute); got != time.Minute {
+		t.Errorf("envDuration = %v, want fallback 1m", got)
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+)
+
+// apiCORS lets front-ends on the given origins call the API from the browser. They authenticate with a
+// token from /api/login in the Authorization header; credentials are not allowed, so the
+// session cookie is never sent cross-origin and CSRF stays a same-origin concern.
+func apiCORS(origins []string) fiber.Handler {
+	return cors.New(cors.Config{
+		AllowOrigins: strings.Join(origins, ","),
+		AllowMethods: strings.Join([]string{fiber.MethodGet, fiber.MethodPost, fiber.MethodPut, fiber.MethodDelete}, ","),
+		AllowHeaders: strings.Join([]string{fiber.HeaderAuthorization, fiber.HeaderContentType, fiber.HeaderAccept}, ","),
+		// Lets clients read rate limit advice and quote request IDs
+		ExposeHeaders: strings.Join([]string{fiber.HeaderRetryAfter, fiber.HeaderXRequestID}, ","),
+		MaxAge:        600,
+	})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/csrf"
+	"github.com/gofiber/fiber/v2/middleware/session"
+)
+
+const (
+	// csrfFormField is the hidden input forms carry the token in, csrfHeader is for scripts
+	csrfFormField = "_csrf"
+	csrfHeader    = csrf.HeaderName
+	// csrfSessionKey holds the token in the session; it's Fiber's default, named so login can
+	// drop the token when it starts a new session
+	csrfSessionKey = "fiber.csrf.token"
+)
+
+// csrfProtection rejects state-changing requests without the token from the "csrf" local,
+// which the token is kept next to in the session. Forms send it as the _csrf field, scripts
+// as the X-Csrf-Token header.
+func csrfProtection(sessionStore *session.Store, secure bool) fiber.Handler {
+	return csrf.New(csrf.Config{
+		Session:        sessionStore,
+		ContextKey:     "csrf",
+		SessionKey:     csrfSessionKey,
+		KeyGenerator:   func() string { return secureToken(32) },
+		CookieHTTPOnly: true,
+		CookieSecure:   secure,
+		CookieSameSite: fiber.CookieSameSiteLaxMode,
+		// Lasts as long as a session, so a form left open for a while can still be sent
+		Expiration: 24 * time.Hour,
+		Extractor: func(c *fiber.Ctx) (string, error) {
+			if token := c.FormValue(csrfFormField); token != "" {
+				return token, nil
+			}
+			return c.Get(csrfHeader), nil
+		},
+		// Reading the API doesn't need a token, and issuing one would start a session for
+		// every API client. Token logins, API registrations and bearer-authenticated calls
+		// carry no cookies a browser would send on its own, so they can't be forged
+		// cross-site either.
+		Next: func(c *fiber.Ctx) bool {
+			if !strings.HasPrefix(routePath(c), "/api/") {
+				return false
+			}
+			return isSafeMethod(c.Method()) || routePath(c) == "/api/login" || routePath(c) == "/api/register" || hasBearerToken(c)
+		},
+		ErrorHandler: func(c *fiber.Ctx, err error) error {
+			if wantsJSON(c) {
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "invalid csrf token"})
+			}
+			return c.Status(fiber.StatusForbidden).SendString("Forbidden: the form has expired, please go back, reload the page and try again")
+		},
+	})
+}
+
+// isSafeMethod reports whether method is read-only per RFC 9110
+func isSafeMethod(method string) bool {
+	switch method {
+	case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions, fiber.MethodTrace:
+		return true
+	}
+	return false
+}
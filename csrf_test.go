@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// hiddenFields loads page and returns the hidden fields its forms carry, such as the CSRF token
+func (s *testServer) hiddenFields(page string) url.Values {
+	s.t.Helper()
+	_, body := s.get(page)
+	fields := url.Values{}
+	for _, match := range hiddenFieldPattern.FindAllStringSubmatch(body, -1) {
+		fields.Set(match[1], match[2])
+	}
+	if !fields.Has(csrfFormField) {
+		s.t.Fatalf("no CSRF token on %s", page)
+	}
+	return fields
+}
+
+func TestCSRFProtection(t *testing.T) {
+	s := newTestServer(t)
+
+	// A token is only good with the session it was issued to
+	otherToken := s.hiddenFields("/register").Get(csrfFormField)
+	s.cookies = map[string]string{}
+	fields := s.hiddenFields("/register")
+	token := fields.Get(csrfFormField)
+
+	registration := url.Values{"username": {"alice"}, "email": {"alice@example.com"}, "password": {"secret123"}}
+	registration.Set("form_nonce", fields.Get("form_nonce"))
+	tests := []struct {
+		name        string
+		path        string
+		body        string
+		contentType string
+		header      string
+		want        int
+	}{
+		{"form without a token", "/register", registration.Encode(), fiber.MIMEApplicationForm, "", fiber.StatusForbidden},
+		{"form with a made-up token", "/register", registration.Encode() + "&_csrf=forged", fiber.MIMEApplicationForm, "", fiber.StatusForbidden},
+		{"form with another session's token", "/register", registration.Encode() + "&_csrf=" + otherToken, fiber.MIMEApplicationForm, "", fiber.StatusForbidden},
+		{"JSON without a token", "/api/v1/users", `{}`, fiber.MIMEApplicationJSON, "", fiber.StatusForbidden},
+		{"API registration", "/api/register", `{"username":"bobby","email":"bobby@example.com","password":"secret123"}`, fiber.MIMEApplicationJSON, "", fiber.StatusCreated},
+		{"token in the header", "/register", registration.Encode(), fiber.MIMEApplicationForm, token, fiber.StatusFound},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(fiber.MethodPost, tt.path, strings.NewReader(tt.body))
+		req.Header.Set(fiber.HeaderContentType, tt.contentType)
+		if tt.header != "" {
+			req.Header.Set(csrfHeader, tt.header)
+		}
+		if resp := s.do(req); resp.StatusCode != tt.want {
+			t.Errorf("%s: answered %d, want %d", tt.name, resp.StatusCode, tt.want)
+		}
+	}
+
+	var count int64
+	if err := s.db.Model(&User{}).Where("username = ?", "alice").Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("%d accounts for alice, want only the one registered with the token", count)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// Database drivers accepted by DB_DRIVER
+const (
+	dbDriverSQLite   = "sqlite"
+	dbDriverPostgres = "postgres"
+	dbDriverMySQL    = "mysql"
+)
+
+// openDB connects to the database picked by DB_DRIVER. SQLite opens DB_DSN if it's set and
+// DB_PATH otherwise, so the zero-config default is still site.db next to the binary.
+func openDB(cfg Config) (*gorm.DB, error) {
+	var dialector gorm.Dialector
+	switch cfg.DBDriver {
+	case dbDriverSQLite:
+		dsn := cfg.DBDSN
+		if dsn == "" {
+			dsn = cfg.DBPath
+		}
+		dialector = sqlite.Open(dsn)
+	case dbDriverPostgres:
+		dialector = postgres.Open(cfg.DBDSN)
+	case dbDriverMySQL:
+		dialector = mysql.Open(cfg.DBDSN)
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", cfg.DBDriver)
+	}
+	// TranslateError turns each driver's unique violation into gorm.ErrDuplicatedKey
+	return gorm.Open(dialector, &gorm.Config{TranslateError: true})
+}
+
+// migrate creates or updates the tables for every model
+func migrate(db *gorm.DB) error {
+	if err := db.AutoMigrate(&User{}, &SecurityAnswer{}, &Label{}, &UserSession{}); err != nil {
+		return err
+	}
+	return normalizeUsernames(db)
+}
+
+// normalizeUsernames rewrites usernames stored before they were normalized, see
+// normalizeUsername, so lookups can match the username column exactly and use its index.
+// Accounts whose names only differ in case or spaces can't be merged automatically, so they
+// fail the migration until one of them is renamed.
+func normalizeUsernames(db *gorm.DB) error {
+	var users []User
+	if err := db.Unscoped().Select("id", "username").Order("id").Find(&users).Error; err != nil {
+		return err
+	}
+	owners := make(map[string]string, len(users))
+	var renames []User
+	for _, user := range users {
+		normalized := normalizeUsername(user.Username)
+		if other, ok := owners[normalized]; ok {
+			return fmt.Errorf("usernames %q and %q are the same once normalized, rename one of them", other, user.Username)
+		}
+		owners[normalized] = user.Username
+		if normalized != user.Username {
+			renames = append(renames, User{Model: gorm.Model{ID: user.ID}, Username: normalized})
+		}
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, user := range renames {
+			if err := tx.Unscoped().Model(&User{}).Where("id = ?", user.ID).Update("username", user.Username).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"runtime"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// version is the build version, set with -ldflags "-X main.version=v1.2.3"
+var version = "dev"
+
+// startedAt is used to report uptime
+var startedAt = time.Now()
+
+// debugInfo reports runtime statistics and the effective configuration for GET /admin/debug/info
+func (h *Handlers) debugInfo(c *fiber.Ctx) error {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	return c.JSON(fiber.Map{
+		"version":    version,
+		"go_version": runtime.Version(),
+		"uptime":     time.Since(startedAt).Round(time.Second).String(),
+		"goroutines": runtime.NumGoroutine(),
+		"memory": fiber.Map{
+			"alloc_bytes":       mem.Alloc,
+			"total_alloc_bytes": mem.TotalAlloc,
+			"sys_bytes":         mem.Sys,
+			"heap_objects":      mem.HeapObjects,
+		},
+		"gc": fiber.Map{
+			"count":          mem.NumGC,
+			"pause_total_ns": mem.PauseTotalNs,
+			"last_run":       time.Unix(0, int64(mem.LastGC)),
+		},
+		"config": redactedConfig(h.cfg),
+	})
+}
+
+// redactedConfig returns the config as a map keyed by field name, with fields tagged
+// `debug:"redact"` masked and URLs tagged `debug:"url"` shown without their credentials, so
+// secrets never leave the process
+func redactedConfig(cfg Config) map[string]any {
+	v := reflect.ValueOf(cfg)
+	t := v.Type()
+	out := make(map[string]any, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		switch value := v.Field(i).Interface().(type) {
+		case time.Duration:
+			out[field.Name] = value.String()
+		default:
+			switch field.Tag.Get("debug") {
+			case "redact":
+				out[field.Name] = "[redacted]"
+			case "url":
+				out[field.Name] = redactURL(value.(string))
+			default:
+				out[field.Name] = value
+			}
+		}
+	}
+	return out
+}
+
+// redactURL masks the user info of rawURL, which may be a token on its own as well as a
+// username and password. A URL that doesn't parse is masked entirely.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "[redacted]"
+	}
+	if u.User != nil {
+		u.User = url.User("redacted")
+	}
+	return u.String()
+}
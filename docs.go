@@ -0,0 +1,17 @@
+package main
+
+import (
+	_ "embed"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// openAPISpec is the hand-maintained description of the API; update it along with the routes
+//
+//go:embed docs/openapi.json
+var openAPISpec []byte
+
+func serveOpenAPISpec(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return c.Send(openAPISpec)
+}
@@ -0,0 +1,10 @@
+package main
+
+import "log/slog"
+
+// sendEmail is where outgoing email would be sent. The template has no mail server to talk
+// to, so it logs the message instead; swap the body for an SMTP or provider API call.
+func sendEmail(to, subject, body string) error {
+	slog.Info("email not sent, no mail server configured", "to", to, "subject", subject, "body", body)
+	return nil
+}
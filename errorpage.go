@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// errorHandler renders the error pages for browsers: 404.html when nothing matched the route,
+// and 500.html for server errors, which are logged since the page doesn't show them. API
+// clients and the other client errors get Fiber's default plain response.
+func errorHandler(flash *FlashManager) fiber.ErrorHandler {
+	return func(c *fiber.Ctx, err error) error {
+		code := fiber.StatusInternalServerError
+		var e *fiber.Error
+		if errors.As(err, &e) {
+			code = e.Code
+		}
+		if code >= fiber.StatusInternalServerError {
+			slog.Error("error handling request", "method", c.Method(), "path", c.Path(), "request_id", currentRequestID(c), "error", err)
+		}
+
+		var template string
+		switch {
+		case code == fiber.StatusNotFound:
+			template = "404"
+		case code >= fiber.StatusInternalServerError:
+			template = "500"
+		}
+		if template == "" || !wantsHTML(c) {
+			return fiber.DefaultErrorHandler(c, err)
+		}
+
+		// Rendering is what failed for some errors, so the page may not render either
+		c.Status(code)
+		if renderErr := c.Render(template, prepareTemplateData(c, nil, flash)); renderErr != nil {
+			slog.Error("error rendering the error page", "status", code, "error", renderErr)
+			return c.SendStatus(code)
+		}
+		return nil
+	}
+}
+
+// notFound is the last handler, for requests no route matched
+func notFound(c *fiber.Ctx) error {
+	return fiber.ErrNotFound
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Session binding modes, see Config.SessionBinding
+const (
+	sessionBindingOff     = "off"
+	sessionBindingLenient = "lenient"
+	sessionBindingStrict  = "strict"
+)
+
+// deviceCookieName holds a random ID identifying the browser, independent of any session
+const deviceCookieName = "device_id"
+
+// deviceFingerprint ties a session to the browser it was created in. It is stored in the
+// session, so it must be registered with gob.
+type deviceFingerprint struct {
+	DeviceID  string
+	UserAgent string
+}
+
+// versionNumbers matches the version parts of a user agent, e.g. "124.0.6367.91"
+var versionNumbers = regexp.MustCompile(`[0-9][0-9._]*`)
+
+// newDeviceFingerprint builds the fingerprint for the current request, issuing a device
+// cookie first if the browser doesn't have one yet
+func newDeviceFingerprint(c *fiber.Ctx) deviceFingerprint {
+	deviceID := c.Cookies(deviceCookieName)
+	if deviceID == "" {
+		deviceID = secureToken(16)
+		c.Cookie(&fiber.Cookie{
+			Name:     deviceCookieName,
+			Value:    deviceID,
+			Path:     cookiePath(c),
+			Expires:  time.Now().AddDate(1, 0, 0),
+			HTTPOnly: true,
+			SameSite: fiber.CookieSameSiteLaxMode,
+		})
+	}
+	return deviceFingerprint{DeviceID: deviceID, UserAgent: c.Get(fiber.HeaderUserAgent)}
+}
+
+// matches reports whether the request comes from the device the fingerprint was taken on.
+// The device cookie must always match. Strict mode also requires the exact same user agent,
+// while lenient mode ignores version numbers so browser updates don't end the session.
+func (f deviceFingerprint) matches(c *fiber.Ctx, mode string) bool {
+	if f.DeviceID == "" || c.Cookies(deviceCookieName) != f.DeviceID {
+		return false
+	}
+	userAgent := c.Get(fiber.HeaderUserAgent)
+	if mode == sessionBindingStrict {
+		return userAgent == f.UserAgent
+	}
+	return versionNumbers.ReplaceAllString(userAgent, "") == versionNumbers.ReplaceAllString(f.UserAgent, "")
+}
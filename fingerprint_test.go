@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+func TestDeviceFingerprintMatches(t *testing.T) {
+	const chrome124 = "Mozilla/5.0 (X11; Linux x86_64) Chrome/124.0.6367.91 Safari/537.36"
+	const chrome125 = "Mozilla/5.0 (X11; Linux x86_64) Chrome/125.0.6422.60 Safari/537.36"
+	const firefox = "Mozilla/5.0 (X11; Linux x86_64; rv:126.0) Gecko/20100101 Firefox/126.0"
+	fingerprint := deviceFingerprint{DeviceID: "device-1", UserAgent: chrome124}
+
+	tests := []struct {
+		name      string
+		mode      string
+		deviceID  string
+		userAgent string
+		want      bool
+	}{
+		{"same browser", sessionBindingStrict, "device-1", chrome124, true},
+		{"other device cookie", sessionBindingLenient, "device-2", chrome124, false},
+		{"no device cookie", sessionBindingLenient, "", chrome124, false},
+		{"browser update, lenient", sessionBindingLenient, "device-1", chrome125, true},
+		{"browser update, strict", sessionBindingStrict, "device-1", chrome125, false},
+		{"other browser", sessionBindingLenient, "device-1", firefox, false},
+	}
+
+	app := fiber.New()
+	for _, tt := range tests {
+		c := app.AcquireCtx(&fasthttp.RequestCtx{})
+		if tt.deviceID != "" {
+			c.Request().Header.SetCookie(deviceCookieName, tt.deviceID)
+		}
+		c.Request().Header.SetUserAgent(tt.userAgent)
+		if got := fingerprint.matches(c, tt.mode); got != tt.want {
+			t.Errorf("%s: matches = %v, want %v", tt.name, got, tt.want)
+		}
+		app.ReleaseCtx(c)
+	}
+}
+
+func TestSessionBindingEndsSessionsFromOtherDevices(t *testing.T) {
+	t.Setenv("SESSION_BINDING", sessionBindingLenient)
+	s := newTestServer(t)
+	createTestUser(t, s.db, "alice", "secret123")
+
+	resp := s.submit("/login", "/login", url.Values{"username": {"alice"}, "password": {"secret123"}})
+	expectRedirect(t, "login", resp, "/")
+	device := s.cookies[deviceCookieName]
+	if device == "" {
+		t.Fatal("no device cookie after logging in")
+	}
+	if resp, _ := s.get("/profile"); resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("profile from the same device answered %d", resp.StatusCode)
+	}
+
+	// A copied session cookie doesn't work without the device cookie it was bound to
+	s.cookies[deviceCookieName] = "someone-elses-device"
+	resp, _ = s.get("/profile")
+	expectRedirect(t, "profile from another device", resp, "/login?next=%2Fprofile")
+
+	// The mismatch ended the session, so it's gone for the original device as well
+	s.cookies[deviceCookieName] = device
+	resp, _ = s.get("/profile")
+	expectRedirect(t, "profile after the mismatch", resp, "/login?next=%2Fprofile")
+}
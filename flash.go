@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/gob"
+	"log"
+	"log/slog"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/session"
+)
+
+// FlashManager stores flash messages until the next page is rendered, in the session or, see
+// Config.FlashStorage, in a signed cookie. It's created once at startup and shared by handlers.
+type FlashManager struct {
+	store *session.Store
+	mode  string
+	// key signs the flash cookie
+	key []byte
+	// htmx sends flashes to HTMX partial requests in an HX-Trigger header, see Config.HTMXFlashes
+	htmx bool
+}
+
+// NewFlashManager returns a FlashManager keeping flashes as mode says. Without a secret a random
+// key signs the cookie, so cookie flashes in flight are lost on restart.
+func NewFlashManager(store *session.Store, mode, secret string, htmx bool) *FlashManager {
+	// Flashes are kept in the session as this type, which gob has to know to encode it
+	gob.Register([]map[string]string{})
+
+	m := &FlashManager{store: store, mode: mode, htmx: htmx}
+	if secret != "" {
+		m.key = []byte(secret)
+	} else {
+		m.key = make([]byte, 32)
+		if _, err := rand.Read(m.key); err != nil {
+			log.Fatalf("failed to generate flash cookie key: %v", err)
+		}
+	}
+	return m
+}
+
+// Add queues a message for the next rendered page; category is a Bootstrap alert type such
+// as "success" or "danger". A message that can't be stored is logged and dropped, since the
+// request it belongs to has already done its work.
+func (m *FlashManager) Add(c *fiber.Ctx, message, category string) {
+	if err := m.add(c, map[string]string{"message": message, "category": category}); err != nil {
+		slog.Error("flash message lost", "error", err, "message", message)
+	}
+}
+
+func (m *FlashManager) add(c *fiber.Ctx, entry map[string]string) error {
+	if m.mode == flashStorageCookie {
+		return m.addCookie(c, entry)
+	}
+
+	sess, err := m.store.Get(c)
+	if err != nil {
+		if m.mode == flashStorageFallback {
+			return m.addCookie(c, entry)
+		}
+		return err
+	}
+	flashes, _ := sess.Get("flashes").([]map[string]string)
+	flashes = append(flashes, entry)
+	sess.Set("flashes", flashes)
+	if err := saveSession(sess); err != nil {
+		// Don't lose the message just because the session backend is having trouble
+		if m.mode == flashStorageFallback {
+			slog.Error("error saving session", "error", err)
+			return m.addCookie(c, entry)
+		}
+		return err
+	}
+	return nil
+}
+
+// Drain returns the queued flashes, oldest first, and clears them so they're only shown once
+func (m *FlashManager) Drain(c *fiber.Ctx) []map[string]string {
+	// Flashes carried by the signed cookie, if it's in use
+	flashes := m.drainCookie(c)
+
+	sess, err := m.store.Get(c)
+	if err != nil {
+		slog.Error("error fetching session", "error", err)
+	} else if f, ok := sess.Get("flashes").([]map[string]string); ok {
+		flashes = append(f, flashes...)
+		sess.Delete("flashes")
+		saveSession(sess)
+	}
+	return flashes
+}
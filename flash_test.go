@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/session"
+)
+
+func TestFlashManagerAddAndDrain(t *testing.T) {
+	for _, mode := range []string{flashStorageSession, flashStorageCookie} {
+		t.Run(mode, func(t *testing.T) {
+			flash := NewFlashManager(session.New(), mode, "test-secret", false)
+
+			app := fiber.New()
+			app.Get("/", func(c *fiber.Ctx) error {
+				flash.Add(c, "Saved", "success")
+				flash.Add(c, "Check your email", "warning")
+
+				want := []map[string]string{
+					{"message": "Saved", "category": "success"},
+					{"message": "Check your email", "category": "warning"},
+				}
+				if got := flash.Drain(c); !reflect.DeepEqual(got, want) {
+					t.Errorf("first Drain = %v, want %v", got, want)
+				}
+				if got := flash.Drain(c); len(got) != 0 {
+					t.Errorf("second Drain = %v, want no flashes", got)
+				}
+				return nil
+			})
+
+			resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/", nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != fiber.StatusOK {
+				t.Errorf("status = %d, want 200", resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestFlashCookieReachesOtherPaths(t *testing.T) {
+	for _, base := range []string{"", "/app"} {
+		t.Run("base path "+base, func(t *testing.T) {
+			flash := NewFlashManager(session.New(), flashStorageCookie, "test-secret", false)
+			app := fiber.New()
+			app.Use(withBasePath(base))
+			router := app.Group(base)
+			// Set on one subpath, shown on another, and gone on a third page
+			router.Post("/verify/resend", func(c *fiber.Ctx) error {
+				flash.Add(c, "Link sent", "success")
+				return redirect(c, "/profile/security")
+			})
+			router.Get("/*", func(c *fiber.Ctx) error {
+				var messages []string
+				for _, f := range flash.Drain(c) {
+					messages = append(messages, f["message"])
+				}
+				return c.SendString(strings.Join(messages, ","))
+			})
+
+			// A real jar, since what's being tested is the cookies' paths
+			jar, err := cookiejar.New(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			visit := func(method, path string) string {
+				t.Helper()
+				target, _ := url.Parse("http://example.com" + base + path)
+				req := httptest.NewRequest(method, target.String(), nil)
+				for _, cookie := range jar.Cookies(target) {
+					req.AddCookie(cookie)
+				}
+				resp, err := app.Test(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				jar.SetCookies(target, resp.Cookies())
+				body, _ := io.ReadAll(resp.Body)
+				return string(body)
+			}
+
+			visit(fiber.MethodPost, "/verify/resend")
+			if got := visit(fiber.MethodGet, "/profile/security"); got != "Link sent" {
+				t.Errorf("flashes at the redirect target = %q, want the one set on /verify/resend", got)
+			}
+			if got := visit(fiber.MethodGet, "/login"); got != "" {
+				t.Errorf("flashes after they were shown = %q, want none", got)
+			}
+		})
+	}
+}
+
+// failingStorage is a session backend that's down
+type failingStorage struct{}
+
+func (failingStorage) Get(string) ([]byte, error)              { return nil, nil }
+func (failingStorage) Set(string, []byte, time.Duration) error { return errors.New("storage is down") }
+func (failingStorage) Delete(string) error                     { return nil }
+func (failingStorage) Reset() error                            { return nil }
+func (failingStorage) Close() error                            { return nil }
+
+func TestFlashManagerLogsLostFlashes(t *testing.T) {
+	logs := captureLogs(t)
+	flash := NewFlashManager(session.New(session.Config{Storage: failingStorage{}}), flashStorageSession, "test-secret", false)
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		flash.Add(c, "Saved", "success")
+		return nil
+	})
+
+	if _, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logs.String(), `msg="flash message lost" error="storage is down" message=Saved`) {
+		t.Errorf("a flash the session couldn't store wasn't logged, logs:\n%s", logs)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Flash storage modes, see Config.FlashStorage
+const (
+	flashStorageSession  = "session"
+	flashStorageCookie   = "cookie"
+	flashStorageFallback = "fallback"
+)
+
+const (
+	flashCookieName = "flash"
+	// Flashes only need to survive the redirect to the next page
+	flashCookieMaxAge = time.Minute
+	// Keeps the cookie well under the usual 4KB limit
+	maxCookieFlashes = 5
+)
+
+// addCookie appends a flash to the signed cookie, keeping any not yet shown
+func (m *FlashManager) addCookie(c *fiber.Ctx, flash map[string]string) error {
+	flashes, _ := c.Locals(flashCookieName).([]map[string]string)
+	if flashes == nil {
+		flashes = m.readCookie(c)
+	}
+	flashes = append(flashes, flash)
+	if len(flashes) > maxCookieFlashes {
+		flashes = flashes[len(flashes)-maxCookieFlashes:]
+	}
+	c.Locals(flashCookieName, flashes)
+
+	payload, err := json.Marshal(flashes)
+	if err != nil {
+		return err
+	}
+	value := base64.RawURLEncoding.EncodeToString(payload)
+	c.Cookie(&fiber.Cookie{
+		Name:     flashCookieName,
+		Value:    value + "." + m.sign(value),
+		Path:     cookiePath(c),
+		MaxAge:   int(flashCookieMaxAge.Seconds()),
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+	return nil
+}
+
+// drainCookie returns the flashes carried by the cookie, including any added during this
+// request, and clears the cookie
+func (m *FlashManager) drainCookie(c *fiber.Ctx) []map[string]string {
+	flashes, _ := c.Locals(flashCookieName).([]map[string]string)
+	if flashes == nil {
+		flashes = m.readCookie(c)
+	}
+	if len(flashes) > 0 {
+		c.Locals(flashCookieName, []map[string]string{})
+		clearCookie(c, flashCookieName)
+	}
+	return flashes
+}
+
+// readCookie decodes the request's flash cookie, ignoring it if the signature is wrong
+func (m *FlashManager) readCookie(c *fiber.Ctx) []map[string]string {
+	value, signature, ok := strings.Cut(c.Cookies(flashCookieName), ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(m.sign(value))) {
+		return nil
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil
+	}
+	var flashes []map[string]string
+	if err := json.Unmarshal(payload, &flashes); err != nil {
+		return nil
+	}
+	return flashes
+}
+
+func (m *FlashManager) sign(value string) string {
+	mac := hmac.New(sha256.New, m.key)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// formValues returns every value submitted for a repeated form field, such as a
+// <select multiple> or a group of checkboxes. BodyParser only keeps one value per
+// field, so this reads the raw form instead. Blank and duplicate values are dropped.
+func formValues(c *fiber.Ctx, key string) []string {
+	var raw []string
+	if strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEMultipartForm) {
+		if form, err := c.MultipartForm(); err == nil {
+			raw = form.Value[key]
+		}
+	} else {
+		for _, v := range c.Request().PostArgs().PeekMulti(key) {
+			raw = append(raw, string(v))
+		}
+	}
+
+	seen := make(map[string]bool, len(raw))
+	values := make([]string, 0, len(raw))
+	for _, v := range raw {
+		v = strings.TrimSpace(v)
+		if v == "" || seen[v] {
+			continue
+		}
+		seen[v] = true
+		values = append(values, v)
+	}
+	return values
+}
+
+// allowedValues reports whether every value appears in allowed
+func allowedValues(values, allowed []string) bool {
+	for _, v := range values {
+		found := false
+		for _, a := range allowed {
+			if v == a {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,592 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/session"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// Handlers holds what the route handlers share; setupRoutes registers its methods. Each method
+// is a plain fiber.Handler, so a test can mount one on its own with a test database.
+type Handlers struct {
+	db       *gorm.DB
+	sessions *session.Store
+	flash    *FlashManager
+	cfg      Config
+	tokens   *apiTokens
+}
+
+func newHandlers(db *gorm.DB, sessionStore *session.Store, flash *FlashManager, cfg Config) *Handlers {
+	return &Handlers{db: db, sessions: sessionStore, flash: flash, cfg: cfg, tokens: newAPITokens(cfg.JWTSecret, cfg.JWTTTL)}
+}
+
+// loginNext is the page to return to after logging in. It comes from ?next= on the way in, and
+// from the form's hidden field when the page is shown again after a failed attempt.
+func loginNext(c *fiber.Ctx) string {
+	next := c.Query("next")
+	if next == "" {
+		next = c.FormValue("next")
+	}
+	if !isLocalPath(next) {
+		return ""
+	}
+	return next
+}
+
+// index is the homepage. API clients get a small status document pointing them at the API.
+func (h *Handlers) index(c *fiber.Ctx) error {
+	if wantsJSON(c) {
+		links := fiber.Map{}
+		if h.cfg.APIDocs {
+			links["docs"] = h.cfg.BasePath + "/api/docs"
+			links["openapi"] = h.cfg.BasePath + "/api/v1/openapi.json"
+		}
+		return c.JSON(fiber.Map{
+			"name":    "Fiber Template",
+			"version": version,
+			"status":  "ok",
+			"links":   links,
+		})
+	}
+	return c.Render("index", prepareTemplateData(c, nil, h.flash))
+}
+
+// renderRegister shows the registration form with a fresh nonce, since any earlier one is used up
+func (h *Handlers) renderRegister(c *fiber.Ctx) error {
+	data := fiber.Map{}
+	if h.cfg.FormNonces {
+		nonce, err := issueFormNonce(c, h.sessions, "register")
+		if err != nil {
+			return err
+		}
+		data["FormNonce"] = nonce
+	}
+	return c.Render("register", prepareTemplateData(c, data, h.flash))
+}
+
+// showRegister answers GET /register
+func (h *Handlers) showRegister(c *fiber.Ctx) error {
+	if currentUser(c) != nil {
+		h.flash.Add(c, "Cannot register, please logout first", "danger")
+		return redirect(c, "/")
+	}
+	return h.renderRegister(c)
+}
+
+// renderLogin shows the login form, which sends the user on to loginNext once they log in
+func (h *Handlers) renderLogin(c *fiber.Ctx) error {
+	return c.Render("login", prepareTemplateData(c, fiber.Map{
+		"SecurityQuestions": h.cfg.SecurityQuestions,
+		"Next":              loginNext(c),
+	}, h.flash))
+}
+
+// showLogin answers GET /login, sending users who are already logged in on their way
+func (h *Handlers) showLogin(c *fiber.Ctx) error {
+	if currentUser(c) != nil {
+		h.flash.Add(c, "Already logged in", "danger")
+		if next := loginNext(c); next != "" {
+			return redirect(c, next)
+		}
+		return redirect(c, "/")
+	}
+	return h.renderLogin(c)
+}
+
+// register creates an account from the registration form and emails its verification link and,
+// when WELCOME_EMAIL is on, a welcome message
+func (h *Handlers) register(c *fiber.Ctx) error {
+	if h.cfg.ReadOnly {
+		h.flash.Add(c, readOnlyMessage, "warning")
+		return h.renderRegister(c)
+	}
+
+	// Parse the form
+	var data struct {
+		Username  string `form:"username"`
+		Email     string `form:"email"`
+		Password  string `form:"password"`
+		FormNonce string `form:"form_nonce"`
+	}
+	if err := c.BodyParser(&data); err != nil {
+		return err
+	}
+
+	// Reject a second submission of the same form, e.g. from a double click
+	if h.cfg.FormNonces {
+		fresh, err := consumeFormNonce(c, h.sessions, "register", data.FormNonce)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			h.flash.Add(c, "This form was already submitted", "warning")
+			return redirect(c, "/")
+		}
+	}
+
+	newUser, token, validation, err := registerUser(c.UserContext(), h.db, h.cfg, data.Username, data.Email, data.Password)
+	if err != nil {
+		if isReadOnlyError(err) {
+			h.flash.Add(c, readOnlyMessage, "warning")
+			return h.renderRegister(c)
+		}
+		return err
+	}
+	if !validation.Valid() {
+		validation.ToFlashes(c, h.flash)
+		return h.renderRegister(c)
+	}
+
+	if err := sendVerificationEmail(h.cfg, newUser, token); err != nil {
+		// The account exists either way, and the profile page offers a new link
+		slog.Error("error sending verification email", "error", err)
+	}
+	sendWelcomeEmail(h.cfg, newUser)
+
+	if h.cfg.WelcomeFlash {
+		h.flash.Add(c, "Registration successful! Welcome, "+newUser.Username+", log in to get started", "success")
+	}
+
+	// Redirect to the onboarding page, the homepage by default
+	return redirect(c, h.cfg.WelcomeRedirect)
+}
+
+// login checks the login form and starts a session, for longer if "remember me" was ticked
+func (h *Handlers) login(c *fiber.Ctx) error {
+	var data struct {
+		Username string `form:"username"`
+		Password string `form:"password"`
+		Remember bool   `form:"remember"`
+	}
+	if err := c.BodyParser(&data); err != nil {
+		return err
+	}
+
+	next := loginNext(c)
+	user, err := authenticate(c.UserContext(), h.db, data.Username, data.Password)
+	if errors.Is(err, errInvalidCredentials) {
+		h.flash.Add(c, "Invalid username or password", "danger")
+		return redirect(c, loginPath(next))
+	}
+	if errors.Is(err, errAccountLocked) {
+		h.flash.Add(c, "This account is temporarily locked after too many failed logins, please try again later", "danger")
+		return redirect(c, loginPath(next))
+	}
+	if err != nil {
+		return err
+	}
+	// A read-only database can't keep the time, which shouldn't stop the login
+	if err := recordLogin(c.UserContext(), h.db, user); err != nil {
+		if !isReadOnlyError(err) {
+			return err
+		}
+		slog.Warn("last login not recorded, the database is read-only")
+	}
+
+	// Create session and store only user_id
+	sess, err := h.sessions.Get(c)
+	if err != nil {
+		return err
+	}
+	// Logging in gets a new session ID, so an ID planted before login, e.g. through a visit that
+	// got a CSRF session, can't be used once it's logged in. The CSRF token is issued again too.
+	if err := sess.Regenerate(); err != nil {
+		return err
+	}
+	sess.Delete(csrfSessionKey)
+	// Later lookups in this request, like the flash below, read the ID from the request cookie
+	c.Request().Header.SetCookie("session_id", sess.ID())
+	// Remembered logins last a fixed time; others slide with activity, up to endsAt
+	lifetime := rememberMeLifetime
+	endsAt := time.Now().Add(rememberMeLifetime)
+	if data.Remember {
+		sess.Delete("max_expires_at")
+	} else {
+		lifetime = min(h.cfg.SessionIdleTimeout, h.cfg.SessionMaxLifetime)
+		endsAt = time.Now().Add(h.cfg.SessionMaxLifetime)
+		sess.Set("max_expires_at", endsAt.Unix())
+	}
+	expiresAt := time.Now().Add(lifetime)
+	sess.Set("user_id", user.ID)
+	// Kept as Unix seconds; saveSession makes it the expiry of every later save
+	sess.Set("expires_at", expiresAt.Unix())
+	if h.cfg.SessionBinding != sessionBindingOff {
+		sess.Set("fingerprint", newDeviceFingerprint(c))
+	}
+	// Save hands the session back to Fiber's pool, so read the ID first
+	sessionID := sess.ID()
+	// Recorded so /logout-all can end it. A read-only database can't record it, which only
+	// leaves this session out of a later logout-all.
+	if err := recordSession(c.UserContext(), h.db, user.ID, sessionID, endsAt); err != nil {
+		if !isReadOnlyError(err) {
+			return err
+		}
+		slog.Warn("session not recorded, the database is read-only")
+	} else {
+		sess.Set("tracked", true)
+	}
+	if err := saveSession(sess); err != nil {
+		return err
+	}
+	logSessionEvent(c, h.cfg, sessionCreated, "login", sessionID, user.ID)
+
+	h.flash.Add(c, "Login successful!", "success")
+	if next != "" {
+		return redirect(c, next)
+	}
+	return redirect(c, "/")
+}
+
+// profile shows the logged-in user's account page
+func (h *Handlers) profile(c *fiber.Ctx) error {
+	// The user itself reaches the template through the "user" local
+	return c.Render("profile", prepareTemplateData(c, fiber.Map{
+		"SecurityQuestions": h.cfg.SecurityQuestions,
+	}, h.flash))
+}
+
+// showChangePassword answers GET /change-password
+func (h *Handlers) showChangePassword(c *fiber.Ctx) error {
+	user := currentUser(c)
+	if user.needsVerification() {
+		return redirectToVerify(c, h.flash)
+	}
+	return c.Render("change_password", prepareTemplateData(c, nil, h.flash))
+}
+
+// changePassword sets a new password once the current one is confirmed
+func (h *Handlers) changePassword(c *fiber.Ctx) error {
+	user := currentUser(c)
+	if user.needsVerification() {
+		return redirectToVerify(c, h.flash)
+	}
+	renderForm := func() error {
+		return c.Render("change_password", prepareTemplateData(c, nil, h.flash))
+	}
+
+	if h.cfg.ReadOnly {
+		h.flash.Add(c, readOnlyMessage, "warning")
+		return renderForm()
+	}
+
+	var data struct {
+		CurrentPassword string `form:"current_password"`
+		NewPassword     string `form:"new_password"`
+		ConfirmPassword string `form:"confirm_password"`
+	}
+	if err := c.BodyParser(&data); err != nil {
+		return err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(data.CurrentPassword)); err != nil {
+		h.flash.Add(c, "Current password is incorrect", "danger")
+		return renderForm()
+	}
+	if validation := validateNewPassword(data.NewPassword, data.ConfirmPassword); !validation.Valid() {
+		validation.ToFlashes(c, h.flash)
+		return renderForm()
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(data.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	user.Password = string(hashedPassword)
+	// API tokens issued with the old password stop working; this session carries on
+	user.TokenVersion++
+	if err := h.db.WithContext(c.UserContext()).Save(user).Error; err != nil {
+		if isReadOnlyError(err) {
+			h.flash.Add(c, readOnlyMessage, "warning")
+			return renderForm()
+		}
+		return err
+	}
+
+	h.flash.Add(c, "Password changed", "success")
+	return redirect(c, "/profile")
+}
+
+// showPreferences answers GET /preferences
+func (h *Handlers) showPreferences(c *fiber.Ctx) error {
+	user := currentUser(c)
+	return c.Render("preferences", prepareTemplateData(c, fiber.Map{
+		"Options":           interestOptions,
+		"Selected":          strings.Split(user.Interests, ","),
+		"SecurityQuestions": h.cfg.SecurityQuestions,
+	}, h.flash))
+}
+
+// savePreferences stores the interests picked on the preferences page
+func (h *Handlers) savePreferences(c *fiber.Ctx) error {
+	user := currentUser(c)
+
+	if h.cfg.ReadOnly {
+		h.flash.Add(c, readOnlyMessage, "warning")
+		return redirect(c, "/preferences")
+	}
+
+	// interests comes from a multi-select, so it may be submitted several times
+	interests := formValues(c, "interests")
+	if result := validateInterests(interests); !result.Valid() {
+		result.ToFlashes(c, h.flash)
+		return redirect(c, "/preferences")
+	}
+
+	user.Interests = strings.Join(interests, ",")
+	if err := h.db.WithContext(c.UserContext()).Save(user).Error; err != nil {
+		if isReadOnlyError(err) {
+			h.flash.Add(c, readOnlyMessage, "warning")
+			return redirect(c, "/preferences")
+		}
+		return err
+	}
+
+	h.flash.Add(c, "Preferences saved", "success")
+	return redirect(c, "/preferences")
+}
+
+// logout ends the current session
+func (h *Handlers) logout(c *fiber.Ctx) error {
+	user := currentUser(c)
+	if user == nil {
+		h.flash.Add(c, "Can't log out, user not logged in", "danger")
+		return redirect(c, "/")
+	}
+	sess, err := h.sessions.Get(c)
+	if err != nil {
+		return err
+	}
+	// Destroy the session
+	sessionID := sess.ID()
+	sess.Destroy()
+	if err := forgetSession(c.UserContext(), h.db, sessionID); err != nil {
+		slog.Error("error forgetting session", "error", err)
+	}
+	logSessionEvent(c, h.cfg, sessionDestroyed, "logout", sessionID, user.ID)
+
+	// Clear the cookie
+	clearCookie(c, "session_id")
+
+	h.flash.Add(c, "Logout successful", "success")
+	return redirect(c, "/")
+}
+
+// logoutAll ends this session and every other, for a user who thinks someone else is logged
+// in as them
+func (h *Handlers) logoutAll(c *fiber.Ctx) error {
+	user := currentUser(c)
+	err := h.db.WithContext(c.UserContext()).Transaction(func(tx *gorm.DB) error {
+		if err := forgetUserSessions(c.UserContext(), tx, user.ID); err != nil {
+			return err
+		}
+		return revokeAPITokens(c.UserContext(), tx, user.ID)
+	})
+	if err != nil {
+		if isReadOnlyError(err) {
+			h.flash.Add(c, readOnlyMessage, "warning")
+			return redirect(c, "/profile")
+		}
+		return err
+	}
+
+	sess, err := h.sessions.Get(c)
+	if err != nil {
+		return err
+	}
+	sessionID := sess.ID()
+	sess.Destroy()
+	logSessionEvent(c, h.cfg, sessionDestroyed, "logout_all", sessionID, user.ID)
+	clearCookie(c, "session_id")
+
+	h.flash.Add(c, "You've been logged out everywhere", "success")
+	return redirect(c, "/login")
+}
+
+// deleteAccount deletes the logged-in user once they re-enter their password
+func (h *Handlers) deleteAccount(c *fiber.Ctx) error {
+	user := currentUser(c)
+
+	if h.cfg.ReadOnly {
+		h.flash.Add(c, readOnlyMessage, "warning")
+		return redirect(c, "/profile")
+	}
+
+	// Re-entering the password confirms it's really the user, not someone at an unlocked screen
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(c.FormValue("password"))); err != nil {
+		h.flash.Add(c, "Password is incorrect, your account was not deleted", "danger")
+		return redirect(c, "/profile")
+	}
+
+	if err := deleteUser(h.db.WithContext(c.UserContext()), user); err != nil {
+		if isReadOnlyError(err) {
+			h.flash.Add(c, readOnlyMessage, "warning")
+			return redirect(c, "/profile")
+		}
+		return err
+	}
+	removeAvatar(h.cfg, user.AvatarPath)
+
+	sess, err := h.sessions.Get(c)
+	if err != nil {
+		return err
+	}
+	sessionID := sess.ID()
+	sess.Destroy()
+	logSessionEvent(c, h.cfg, sessionDestroyed, "account_deleted", sessionID, user.ID)
+	clearCookie(c, "session_id")
+
+	h.flash.Add(c, "Your account has been deleted", "success")
+	return redirect(c, "/")
+}
+
+// adminIndex lists every user for admins, optionally only those with ?label=
+func (h *Handlers) adminIndex(c *fiber.Ctx) error {
+	var users []User
+	query := h.db.WithContext(c.UserContext()).Preload("Labels").Order("users.created_at")
+	label := c.Query("label")
+	if label != "" {
+		query = query.Scopes(withLabel(label))
+	}
+	if err := query.Find(&users).Error; err != nil {
+		return err
+	}
+	var labels []Label
+	if err := h.db.WithContext(c.UserContext()).Order("name").Find(&labels).Error; err != nil {
+		return err
+	}
+
+	type row struct {
+		UserView
+		Labels   []string
+		RateTier string
+	}
+	rows := make([]row, len(users))
+	for i := range users {
+		rows[i] = row{UserView: users[i].ToView(), Labels: users[i].toPublic().Labels, RateTier: users[i].RateTier}
+	}
+	return c.Render("admin", prepareTemplateData(c, fiber.Map{
+		"Users":       rows,
+		"Labels":      labels,
+		"LabelFilter": label,
+		"RateTiers":   userRateTiers(h.cfg),
+	}, h.flash))
+}
+
+// myPermissions returns the logged-in user's role and what it allows
+func (h *Handlers) myPermissions(c *fiber.Ctx) error {
+	user := currentUser(c)
+	return c.JSON(fiber.Map{
+		"role":        user.Role,
+		"permissions": permissionsFor(user.Role),
+	})
+}
+
+// usernameAvailable reports whether ?username= could be registered
+func (h *Handlers) usernameAvailable(c *fiber.Ctx) error {
+	username := normalizeUsername(c.Query("username"))
+	if result := validateUsername(username); !result.Valid() {
+		body := result.ToJSON()
+		body["available"] = false
+		return c.JSON(body)
+	}
+	// Reserved names are reported as taken, so the response doesn't reveal the reserved list
+	if isReservedUsername(username) {
+		return c.JSON(fiber.Map{"available": false})
+	}
+	// A failed check answers 500 rather than guessing; the form only shows successful answers
+	var count int64
+	if err := h.db.WithContext(c.UserContext()).Unscoped().Model(&User{}).Where("username = ?", username).Count(&count).Error; err != nil {
+		return err
+	}
+	return c.JSON(fiber.Map{"available": count == 0})
+}
+
+// listUsers returns a page of users for the users API, filtered by ?label= and ?q=
+func (h *Handlers) listUsers(c *fiber.Ctx) error {
+	tx := h.db.WithContext(c.UserContext())
+
+	// The list only changes when a user is added, updated or removed. Adding or purging a user
+	// moves the count, updating moves the latest UpdatedAt and a soft delete the latest
+	// DeletedAt, so together they version the response. Deleted rows are included when looking
+	// for the latest change, since deleting is one.
+	var count int64
+	if err := tx.Model(&User{}).Count(&count).Error; err != nil {
+		return err
+	}
+	var updated, deleted User
+	if err := tx.Unscoped().Select("updated_at").Order("updated_at DESC").Limit(1).Find(&updated).Error; err != nil {
+		return err
+	}
+	if err := tx.Unscoped().Select("deleted_at").Where("deleted_at IS NOT NULL").Order("deleted_at DESC").Limit(1).Find(&deleted).Error; err != nil {
+		return err
+	}
+	lastModified := updated.UpdatedAt
+	if deleted.DeletedAt.Valid && deleted.DeletedAt.Time.After(lastModified) {
+		lastModified = deleted.DeletedAt.Time
+	}
+	// A table that never had users has no time to version by, and sends no Last-Modified
+	var version int64
+	if !lastModified.IsZero() {
+		version = lastModified.UnixNano()
+	}
+	etag := fmt.Sprintf(`W/"%d-%d"`, count, version)
+	if notModified(c, etag, lastModified) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	query := tx.Model(&User{})
+	if label := c.Query("label"); label != "" {
+		query = query.Scopes(withLabel(label))
+	}
+	query = query.Scopes(withSearch(c.Query("q")))
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return err
+	}
+
+	// Rows past UsersAPIMaxRows are never returned, whatever page is asked for
+	page := parsePage(c)
+	limit := page.PerPage
+	if h.cfg.UsersAPIMaxRows > 0 {
+		limit = min(limit, h.cfg.UsersAPIMaxRows-page.Offset())
+	}
+
+	// Labels are preloaded in one query for the whole page rather than one per user
+	users := []User{}
+	if limit > 0 {
+		err := query.Preload("Labels").Order("users.id").Limit(limit).Offset(page.Offset()).Find(&users).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	public := make([]PublicUser, len(users))
+	for i := range users {
+		public[i] = users[i].toPublic()
+	}
+	return c.JSON(page.envelope(public, total))
+}
+
+// getUser returns one user for the users API
+func (h *Handlers) getUser(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 0)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid user ID"})
+	}
+	var user User
+	if err := h.db.WithContext(c.UserContext()).Preload("Labels").First(&user, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "not found"})
+		}
+		return err
+	}
+	return c.JSON(user.toPublic())
+}
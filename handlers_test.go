@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/session"
+	"github.com/gofiber/template/django/v3"
+)
+
+// newTestHandlers returns Handlers on a test database and an in-memory session store
+func newTestHandlers(t *testing.T, cfg Config) *Handlers {
+	t.Helper()
+	registerSessionTypes()
+	store := session.New()
+	return newHandlers(newTestDB(t), store, NewFlashManager(store, flashStorageSession, "", false), cfg)
+}
+
+// newTestApp returns a Fiber app rendering the real templates. If user is set, every request
+// is made as that user, the way loadUser would set it.
+func newTestApp(user *User) *fiber.App {
+	app := fiber.New(fiber.Config{
+		Views:             django.New("./templates", ".html"),
+		PassLocalsToViews: true,
+	})
+	if user != nil {
+		app.Use(func(c *fiber.Ctx) error {
+			setCurrentUser(c, user)
+			return c.Next()
+		})
+	}
+	return app
+}
+
+// doRequest sends req to app and fails the test if it can't be answered
+func doRequest(t *testing.T, app *fiber.App, req *http.Request) *http.Response {
+	t.Helper()
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// postForm returns a POST request with form as its body
+func postForm(target string, form url.Values) *http.Request {
+	req := httptest.NewRequest(fiber.MethodPost, target, strings.NewReader(form.Encode()))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+	return req
+}
+
+// decodeJSON decodes resp's body into v
+func decodeJSON(t *testing.T, resp *http.Response, v any) {
+	t.Helper()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		t.Fatalf("decoding %q: %v", body, err)
+	}
+}
+
+func TestHandlersIndexJSON(t *testing.T) {
+	h := newTestHandlers(t, Config{APIDocs: true})
+	app := newTestApp(nil)
+	app.Get("/", h.index)
+
+	req := httptest.NewRequest(fiber.MethodGet, "/", nil)
+	req.Header.Set(fiber.HeaderAccept, fiber.MIMEApplicationJSON)
+	resp := doRequest(t, app, req)
+
+	var body struct {
+		Status string            `json:"status"`
+		Links  map[string]string `json:"links"`
+	}
+	decodeJSON(t, resp, &body)
+	if body.Status != "ok" || body.Links["openapi"] == "" {
+		t.Errorf("status document = %+v", body)
+	}
+}
+
+func TestHandlersRegister(t *testing.T) {
+	h := newTestHandlers(t, Config{WelcomeRedirect: "/welcome"})
+	app := newTestApp(nil)
+	app.Post("/register", h.register)
+
+	resp := doRequest(t, app, postForm("/register", url.Values{
+		"username": {" NewUser "},
+		"email":    {"new@example.com"},
+		"password": {"secret123"},
+	}))
+	if resp.StatusCode != fiber.StatusFound || resp.Header.Get(fiber.HeaderLocation) != "/welcome" {
+		t.Fatalf("register answered %d to %q, want a redirect to /welcome", resp.StatusCode, resp.Header.Get(fiber.HeaderLocation))
+	}
+
+	var user User
+	if err := h.db.Where("username = ?", "newuser").First(&user).Error; err != nil {
+		t.Fatalf("registered user not stored normalized: %v", err)
+	}
+	if !user.needsVerification() || user.VerificationToken == "" {
+		t.Error("new account isn't waiting for email verification")
+	}
+
+	// The same name again re-renders the form instead of redirecting
+	resp = doRequest(t, app, postForm("/register", url.Values{
+		"username": {"newuser"},
+		"email":    {"other@example.com"},
+		"password": {"secret123"},
+	}))
+	if resp.StatusCode != fiber.StatusOK {
+		t.Errorf("duplicate registration answered %d, want the form again", resp.StatusCode)
+	}
+}
+
+// captureLogs collects what is logged, such as the emails sendEmail logs, until the test ends
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var logged bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logged, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &logged
+}
+
+func TestHandlersRegisterWelcomeEmail(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		h := newTestHandlers(t, Config{WelcomeEmail: enabled, WelcomeRedirect: "/"})
+		app := newTestApp(nil)
+		app.Post("/register", h.register)
+		emails := captureLogs(t)
+
+		doRequest(t, app, postForm("/register", url.Values{
+			"username": {"newuser"},
+			"email":    {"new@example.com"},
+			"password": {"secret123"},
+		}))
+		if sent := strings.Contains(emails.String(), "subject=\"Welcome to"); sent != enabled {
+			t.Errorf("WelcomeEmail %v: welcome email sent = %v", enabled, sent)
+		}
+	}
+}
+
+func TestHandlersLogin(t *testing.T) {
+	h := newTestHandlers(t, Config{})
+	createTestUser(t, h.db, "alice", "secret123")
+	app := newTestApp(nil)
+	app.Post("/login", h.login)
+
+	tests := []struct {
+		name     string
+		form     url.Values
+		location string
+		session  bool
+	}{
+		{"valid", url.Values{"username": {"alice"}, "password": {"secret123"}}, "/", true},
+		{"next", url.Values{"username": {"alice"}, "password": {"secret123"}, "next": {"/profile"}}, "/profile", true},
+		{"remote next", url.Values{"username": {"alice"}, "password": {"secret123"}, "next": {"//evil.example"}}, "/", true},
+		{"wrong password", url.Values{"username": {"alice"}, "password": {"wrong"}, "next": {"/profile"}}, "/login?next=%2Fprofile", false},
+	}
+	for _, tt := range tests {
+		resp := doRequest(t, app, postForm("/login", tt.form))
+		if got := resp.Header.Get(fiber.HeaderLocation); got != tt.location {
+			t.Errorf("%s: redirected to %q, want %q", tt.name, got, tt.location)
+		}
+		var recorded int64
+		h.db.Model(&UserSession{}).Count(&recorded)
+		if session := len(resp.Cookies()) > 0 && recorded > 0; session != tt.session {
+			t.Errorf("%s: session started = %v, want %v", tt.name, session, tt.session)
+		}
+		h.db.Where("1 = 1").Delete(&UserSession{})
+	}
+}
+
+func TestHandlersLoginRecordsLastLogin(t *testing.T) {
+	h := newTestHandlers(t, Config{})
+	alice := createTestUser(t, h.db, "alice", "secret123")
+	if alice.LastLoginAt != nil {
+		t.Fatal("new account already has a last login")
+	}
+	app := newTestApp(nil)
+	app.Post("/login", h.login)
+
+	before := time.Now()
+	doRequest(t, app, postForm("/login", url.Values{"username": {"alice"}, "password": {"secret123"}}))
+
+	var user User
+	if err := h.db.First(&user, alice.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if user.LastLoginAt == nil || user.LastLoginAt.Before(before.Add(-time.Second)) {
+		t.Errorf("LastLoginAt = %v after logging in at %v", user.LastLoginAt, before)
+	}
+	if !user.UpdatedAt.Equal(alice.UpdatedAt) {
+		t.Error("logging in moved UpdatedAt, which versions the users listing")
+	}
+}
+
+func TestHandlersUsernameAvailable(t *testing.T) {
+	h := newTestHandlers(t, Config{})
+	createTestUser(t, h.db, "alice", "secret123")
+	app := newTestApp(nil)
+	app.Get("/username-available", h.usernameAvailable)
+
+	for username, want := range map[string]bool{
+		"Alice":   false,
+		"admin":   false, // reserved
+		"abc":     false, // too short
+		"charlie": true,
+	} {
+		resp := doRequest(t, app, httptest.NewRequest(fiber.MethodGet, "/username-available?username="+username, nil))
+		var body struct {
+			Available bool `json:"available"`
+		}
+		decodeJSON(t, resp, &body)
+		if body.Available != want {
+			t.Errorf("%q available = %v, want %v", username, body.Available, want)
+		}
+	}
+}
+
+func TestHandlersUsernameAvailableDatabaseDown(t *testing.T) {
+	h := newTestHandlers(t, Config{})
+	app := newTestApp(nil)
+	app.Get("/username-available", h.usernameAvailable)
+	sqlDB, err := h.db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.Close()
+
+	resp := doRequest(t, app, httptest.NewRequest(fiber.MethodGet, "/username-available?username=charlie", nil))
+	if resp.StatusCode != fiber.StatusInternalServerError {
+		t.Errorf("answered %d with the database down, want 500", resp.StatusCode)
+	}
+}
+
+func TestHandlersMyPermissions(t *testing.T) {
+	h := newTestHandlers(t, Config{})
+	app := newTestApp(&User{Role: RoleAdmin})
+	app.Get("/me/permissions", h.myPermissions)
+
+	resp := doRequest(t, app, httptest.NewRequest(fiber.MethodGet, "/me/permissions", nil))
+	var body struct {
+		Role        string   `json:"role"`
+		Permissions []string `json:"permissions"`
+	}
+	decodeJSON(t, resp, &body)
+	if body.Role != RoleAdmin || len(body.Permissions) == 0 {
+		t.Errorf("permissions = %+v", body)
+	}
+}
+
+func TestHandlersGetUser(t *testing.T) {
+	h := newTestHandlers(t, Config{})
+	alice := createTestUser(t, h.db, "alice", "secret123")
+	app := newTestApp(nil)
+	app.Get("/users/:id", h.getUser)
+
+	tests := []struct {
+		id     string
+		status int
+	}{
+		{"1", fiber.StatusOK},
+		{"2", fiber.StatusNotFound},
+		{"abc", fiber.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		resp := doRequest(t, app, httptest.NewRequest(fiber.MethodGet, "/users/"+tt.id, nil))
+		if resp.StatusCode != tt.status {
+			t.Errorf("GET /users/%s = %d, want %d", tt.id, resp.StatusCode, tt.status)
+		}
+		if tt.status == fiber.StatusOK {
+			var user PublicUser
+			decodeJSON(t, resp, &user)
+			if user.ID != alice.ID || user.Username != "alice" {
+				t.Errorf("GET /users/%s = %+v", tt.id, user)
+			}
+		}
+	}
+}
+
+func TestHandlersLogoutAllNeedsLogin(t *testing.T) {
+	h := newTestHandlers(t, Config{})
+	app := newTestApp(nil)
+	app.Post("/logout-all", requireLogin(h.flash), h.logoutAll)
+
+	resp := doRequest(t, app, httptest.NewRequest(fiber.MethodPost, "/logout-all", nil))
+	if got := resp.Header.Get(fiber.HeaderLocation); !strings.HasPrefix(got, "/login") {
+		t.Errorf("logged out request redirected to %q, want /login", got)
+	}
+}
+
+func TestHandlersListUsersConditionalGet(t *testing.T) {
+	h := newTestHandlers(t, Config{})
+	app := newTestApp(nil)
+	app.Get("/api/users", h.listUsers)
+
+	get := func(header, value string) *http.Response {
+		t.Helper()
+		req := httptest.NewRequest(fiber.MethodGet, "/api/users", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		return doRequest(t, app, req)
+	}
+
+	resp := get("", "")
+	if lastModified := resp.Header.Get(fiber.HeaderLastModified); lastModified != "" {
+		t.Errorf("empty table sent Last-Modified %q", lastModified)
+	}
+	if etag := resp.Header.Get(fiber.HeaderETag); etag != `W/"0-0"` {
+		t.Errorf("empty table's ETag = %q, want W/\"0-0\"", etag)
+	}
+
+	alice := createTestUser(t, h.db, "alice", "secret123")
+	createTestUser(t, h.db, "bobby", "secret123")
+	// An hour back, so the deletion below lands in a later second than Last-Modified
+	if err := h.db.Model(&User{}).Where("1 = 1").UpdateColumn("updated_at", time.Now().Add(-time.Hour)).Error; err != nil {
+		t.Fatal(err)
+	}
+	resp = get("", "")
+	etag, lastModified := resp.Header.Get(fiber.HeaderETag), resp.Header.Get(fiber.HeaderLastModified)
+	if etag == "" || lastModified == "" {
+		t.Fatalf("listing sent ETag %q and Last-Modified %q, want both", etag, lastModified)
+	}
+	if resp := get(fiber.HeaderIfNoneMatch, etag); resp.StatusCode != fiber.StatusNotModified {
+		t.Errorf("If-None-Match with the current ETag answered %d, want 304", resp.StatusCode)
+	}
+	if resp := get(fiber.HeaderIfModifiedSince, lastModified); resp.StatusCode != fiber.StatusNotModified {
+		t.Errorf("If-Modified-Since the current Last-Modified answered %d, want 304", resp.StatusCode)
+	}
+
+	// A soft delete leaves the count of other rows and their UpdatedAt alone
+	if err := h.db.Delete(&alice).Error; err != nil {
+		t.Fatal(err)
+	}
+	if resp := get(fiber.HeaderIfNoneMatch, etag); resp.StatusCode != fiber.StatusOK {
+		t.Errorf("If-None-Match after a delete answered %d, want the new listing", resp.StatusCode)
+	}
+	if resp := get(fiber.HeaderIfModifiedSince, lastModified); resp.StatusCode != fiber.StatusOK {
+		t.Errorf("If-Modified-Since after a delete answered %d, want the new listing", resp.StatusCode)
+	}
+}
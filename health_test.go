@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestHealthCheck(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New()
+	app.Get("/health", healthCheck(db))
+
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/health", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, fiber.StatusOK)
+	}
+	if cookies := resp.Header.Values(fiber.HeaderSetCookie); len(cookies) != 0 {
+		t.Errorf("health check set cookies %q", cookies)
+	}
+
+	// A closed pool fails the ping, like an unreachable database server
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.Close()
+	resp, err = app.Test(httptest.NewRequest(fiber.MethodGet, "/health", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusServiceUnavailable {
+		t.Fatalf("status after close = %d, want %d", resp.StatusCode, fiber.StatusServiceUnavailable)
+	}
+}
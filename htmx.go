@@ -0,0 +1,26 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// isHTMXPartial reports whether the request comes from HTMX and only a fragment of the
+// response will be swapped in. Boosted requests replace the whole body, layout included,
+// so they show flashes like a normal page load.
+func isHTMXPartial(c *fiber.Ctx) bool {
+	return c.Get("HX-Request") == "true" && c.Get("HX-Boosted") != "true"
+}
+
+// triggerFlashes hands flashes to the client as a "flash" event, which static/js/flashes.js
+// shows as alerts. HTMX follows redirects itself, so flashes set before a redirect arrive on
+// the response to the redirected request.
+func triggerFlashes(c *fiber.Ctx, flashes []map[string]string) error {
+	trigger, err := json.Marshal(fiber.Map{"flash": fiber.Map{"messages": flashes}})
+	if err != nil {
+		return err
+	}
+	c.Set("HX-Trigger", string(trigger))
+	return nil
+}
@@ -0,0 +1,23 @@
+package main
+
+import "github.com/gofiber/fiber/v2"
+
+// forceHTTPS permanently redirects plain HTTP requests to the same URL over HTTPS. Behind a
+// proxy, c.Protocol() only trusts X-Forwarded-Proto from TRUSTED_PROXIES, so clients can't
+// skip the redirect by sending the header themselves. Paths in exempt, such as health checks
+// from a load balancer that talks plain HTTP, are left alone.
+func forceHTTPS(exempt []string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if c.Protocol() == "https" {
+			return c.Next()
+		}
+		for _, path := range exempt {
+			if routePath(c) == path {
+				return c.Next()
+			}
+		}
+		// The URI's path and query rather than OriginalURL, which is the whole URL when the
+		// request line carries one, as it may through a proxy
+		return c.Redirect("https://"+c.Hostname()+string(c.Request().URI().RequestURI()), fiber.StatusMovedPermanently)
+	}
+}
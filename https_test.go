@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestForceHTTPS(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies []string
+		target         string
+		forwardedProto string
+		wantLocation   string // "" when the request is let through
+	}{
+		{"plain HTTP", nil, "/login?next=/profile", "", "https://example.com/login?next=/profile"},
+		{"absolute URL in the request line", nil, "http://example.com/login?next=/profile", "", "https://example.com/login?next=/profile"},
+		{"HTTPS at the proxy", []string{"0.0.0.0"}, "/login", "https", ""},
+		{"header from an untrusted client", []string{"10.0.0.1"}, "/login", "https", "https://example.com/login"},
+		{"exempt path", nil, "/health", "", ""},
+	}
+	for _, tt := range tests {
+		app := fiber.New(fiber.Config{EnableTrustedProxyCheck: true, TrustedProxies: tt.trustedProxies})
+		app.Use(forceHTTPS([]string{"/health"}))
+		app.Use(func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+
+		req := httptest.NewRequest(fiber.MethodGet, tt.target, nil)
+		if tt.forwardedProto != "" {
+			req.Header.Set(fiber.HeaderXForwardedProto, tt.forwardedProto)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.wantLocation == "" {
+			if resp.StatusCode != fiber.StatusOK {
+				t.Errorf("%s: answered %d, want the request let through", tt.name, resp.StatusCode)
+			}
+			continue
+		}
+		if resp.StatusCode != fiber.StatusMovedPermanently || resp.Header.Get(fiber.HeaderLocation) != tt.wantLocation {
+			t.Errorf("%s: answered %d to %q, want a redirect to %q", tt.name, resp.StatusCode, resp.Header.Get(fiber.HeaderLocation), tt.wantLocation)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+// bearerPrefix starts the Authorization header of requests authenticated with an API token
+const bearerPrefix = "Bearer "
+
+// apiTokens issues and checks the signed JWTs API clients authenticate with instead of a
+// session cookie. The subject claim is the user ID.
+type apiTokens struct {
+	key []byte
+	ttl time.Duration
+}
+
+// newAPITokens signs with secret, or with a random key if it's empty, which outside production
+// only means tokens stop working when the server restarts
+func newAPITokens(secret string, ttl time.Duration) *apiTokens {
+	if secret == "" {
+		secret = secureToken(32)
+	}
+	return &apiTokens{key: []byte(secret), ttl: ttl}
+}
+
+// apiClaims are the claims of an API token. Version is the user's TokenVersion when it was
+// issued, so raising that revokes the token.
+type apiClaims struct {
+	jwt.RegisteredClaims
+	Version int `json:"ver"`
+}
+
+// issue returns a token for user and when it expires
+func (t *apiTokens) issue(user *User) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(t.ttl)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, apiClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+		Version: user.TokenVersion,
+	})
+	signed, err := token.SignedString(t.key)
+	return signed, expiresAt, err
+}
+
+// parse checks token's signature and expiry and returns the user ID and token version it was
+// issued for
+func (t *apiTokens) parse(token string) (uint, int, error) {
+	var claims apiClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (any, error) {
+		return t.key, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return 0, 0, err
+	}
+	id, err := strconv.ParseUint(claims.Subject, 10, 0)
+	if err != nil {
+		return 0, 0, errors.New("token subject is not a user ID")
+	}
+	return uint(id), claims.Version, nil
+}
+
+// revokeAPITokens makes every API token issued to userID so far stop working, for when its
+// password changes or it logs out everywhere
+func revokeAPITokens(ctx context.Context, db *gorm.DB, userID uint) error {
+	return db.WithContext(ctx).Model(&User{}).Where("id = ?", userID).
+		UpdateColumn("token_version", gorm.Expr("token_version + 1")).Error
+}
+
+// apiLogin answers POST /api/login: it checks JSON credentials and returns a token for them
+func (h *Handlers) apiLogin(c *fiber.Ctx) error {
+	var data struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := c.BodyParser(&data); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+
+	user, err := authenticate(c.UserContext(), h.db, data.Username, data.Password)
+	if errors.Is(err, errInvalidCredentials) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid username or password"})
+	}
+	if errors.Is(err, errAccountLocked) {
+		return c.Status(fiber.StatusLocked).JSON(fiber.Map{"error": "account temporarily locked"})
+	}
+	if err != nil {
+		return err
+	}
+	if err := recordLogin(c.UserContext(), h.db, user); err != nil {
+		if !isReadOnlyError(err) {
+			return err
+		}
+		slog.Warn("last login not recorded, the database is read-only")
+	}
+
+	token, expiresAt, err := h.tokens.issue(user)
+	if err != nil {
+		return err
+	}
+	return c.JSON(fiber.Map{
+		"token":      token,
+		"token_type": "Bearer",
+		"expires_at": expiresAt.UTC(),
+	})
+}
+
+// apiAuthMiddleware logs in the user named by an "Authorization: Bearer <token>" header, the
+// way loadUser does for a session. Requests without the header pass through unchanged, so
+// browsers keep using their session; a header with a bad, expired or revoked token gets 401, and
+// so does one for an account that's locked.
+func apiAuthMiddleware(db *gorm.DB, tokens *apiTokens) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		header := c.Get(fiber.HeaderAuthorization)
+		if header == "" {
+			return c.Next()
+		}
+		if !strings.HasPrefix(header, bearerPrefix) {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+		}
+
+		userID, version, err := tokens.parse(strings.TrimPrefix(header, bearerPrefix))
+		if err != nil {
+			slog.Debug("rejected API token", "error", err)
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+		}
+
+		// The account may have been deleted since the token was issued
+		var user User
+		if err := db.WithContext(c.UserContext()).First(&user, userID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+			}
+			return err
+		}
+		// A login can't happen while the account is locked, so neither can a token's
+		if user.TokenVersion != version || (user.LockedUntil != nil && time.Now().Before(*user.LockedUntil)) {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+		}
+		setCurrentUser(c, &user)
+		return c.Next()
+	}
+}
+
+// hasBearerToken reports whether the request authenticates with an API token rather than cookies
+func hasBearerToken(c *fiber.Ctx) bool {
+	return strings.HasPrefix(c.Get(fiber.HeaderAuthorization), bearerPrefix)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// maxLabelLength bounds label names
+const maxLabelLength = 50
+
+// Label is an admin-defined tag for segmenting users, attached through the user_labels join table
+type Label struct {
+	gorm.Model
+	Name string `gorm:"uniqueIndex"`
+}
+
+// withLabel limits a users query to users that have the named label
+func withLabel(name string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		labelled := db.Session(&gorm.Session{NewDB: true}).Table("user_labels").
+			Select("user_labels.user_id").
+			Joins("JOIN labels ON labels.id = user_labels.label_id").
+			Where("labels.name = ? AND labels.deleted_at IS NULL", name)
+		return db.Where("users.id IN (?)", labelled)
+	}
+}
+
+// listLabels returns every label
+func (h *Handlers) listLabels(c *fiber.Ctx) error {
+	var labels []Label
+	if err := h.db.WithContext(c.UserContext()).Order("name").Find(&labels).Error; err != nil {
+		return err
+	}
+	return c.JSON(labels)
+}
+
+// createLabel creates the label named in the "name" form or JSON field
+func (h *Handlers) createLabel(c *fiber.Ctx) error {
+	var data struct {
+		Name string `json:"name" form:"name"`
+	}
+	if err := c.BodyParser(&data); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid body"})
+	}
+	name := strings.TrimSpace(data.Name)
+	if name == "" || len(name) > maxLabelLength {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "name must be between 1 and 50 characters"})
+	}
+
+	tx := h.db.WithContext(c.UserContext())
+	var count int64
+	if err := tx.Model(&Label{}).Where("name = ?", name).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "label already exists"})
+	}
+	label := Label{Name: name}
+	if err := tx.Create(&label).Error; err != nil {
+		return err
+	}
+	return c.Status(fiber.StatusCreated).JSON(label)
+}
+
+// assignLabel attaches label :label to user :id
+func (h *Handlers) assignLabel(c *fiber.Ctx) error {
+	return h.changeLabel(c, false)
+}
+
+// removeLabel detaches label :label from user :id
+func (h *Handlers) removeLabel(c *fiber.Ctx) error {
+	return h.changeLabel(c, true)
+}
+
+// changeLabel attaches label :label to user :id, or detaches it when remove is set
+func (h *Handlers) changeLabel(c *fiber.Ctx, remove bool) error {
+	// Parsed first, since First would otherwise run a string ID as an SQL condition
+	userID, err := strconv.ParseUint(c.Params("id"), 10, 0)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid user ID"})
+	}
+	labelID, err := strconv.ParseUint(c.Params("label"), 10, 0)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid label ID"})
+	}
+	tx := h.db.WithContext(c.UserContext())
+
+	var user User
+	if err := tx.First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "user not found"})
+		}
+		return err
+	}
+	var label Label
+	if err := tx.First(&label, labelID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "label not found"})
+		}
+		return err
+	}
+
+	err = tx.Transaction(func(tx *gorm.DB) error {
+		association := tx.Model(&user).Omit("Labels.*").Association("Labels")
+		var err error
+		if remove {
+			err = association.Delete(&label)
+		} else {
+			err = association.Append(&label)
+		}
+		if err != nil {
+			return err
+		}
+		// Labels are part of the users listing, so bump UpdatedAt to move its ETag
+		return tx.Model(&user).Update("updated_at", time.Now()).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Model(&user).Association("Labels").Find(&user.Labels); err != nil {
+		return err
+	}
+	return c.JSON(user.Labels)
+}
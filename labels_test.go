@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestAssignLabel(t *testing.T) {
+	h := newTestHandlers(t, Config{})
+	alice := createTestUser(t, h.db, "alice", "secret123")
+	label := Label{Name: "beta"}
+	if err := h.db.Create(&label).Error; err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New()
+	app.Put("/users/:id/labels/:label", h.assignLabel)
+	app.Delete("/users/:id/labels/:label", h.removeLabel)
+
+	tests := []struct {
+		name, method, target string
+		want                 int
+		wantLabels           int
+	}{
+		{"non-numeric user", fiber.MethodPut, "/users/abc/labels/1", fiber.StatusBadRequest, 0},
+		{"SQL in user", fiber.MethodPut, "/users/(0)OR(1=1)/labels/1", fiber.StatusBadRequest, 0},
+		{"SQL in label", fiber.MethodPut, "/users/1/labels/(0)OR(1=1)", fiber.StatusBadRequest, 0},
+		{"unknown user", fiber.MethodPut, "/users/99/labels/1", fiber.StatusNotFound, 0},
+		{"unknown label", fiber.MethodPut, "/users/1/labels/99", fiber.StatusNotFound, 0},
+		{"assign", fiber.MethodPut, "/users/1/labels/1", fiber.StatusOK, 1},
+		{"assign again", fiber.MethodPut, "/users/1/labels/1", fiber.StatusOK, 1},
+		{"remove", fiber.MethodDelete, "/users/1/labels/1", fiber.StatusOK, 0},
+		{"remove again", fiber.MethodDelete, "/users/1/labels/1", fiber.StatusOK, 0},
+	}
+	for _, tt := range tests {
+		resp, err := app.Test(httptest.NewRequest(tt.method, tt.target, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s: %s %s = %d, want %d", tt.name, tt.method, tt.target, resp.StatusCode, tt.want)
+		}
+		if count := h.db.Model(&alice).Association("Labels").Count(); count != int64(tt.wantLabels) {
+			t.Errorf("%s: user has %d labels, want %d", tt.name, count, tt.wantLabels)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+)
+
+// logLevels maps LOG_LEVEL values to slog levels
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// setupLogging points slog's default logger, which the rest of the app logs through, at
+// format and drops messages below level. Text keeps the standard log package's line format.
+func setupLogging(format string, level slog.Level) {
+	if format == logFormatJSON {
+		// Also takes over the standard log package, so every line is JSON
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})))
+		return
+	}
+	slog.SetLogLoggerLevel(level)
+}
@@ -1,282 +1,611 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/gob"
+	"errors"
+	"fmt"
 	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/logger"
+	"github.com/gofiber/fiber/v2/middleware/compress"
+	"github.com/gofiber/fiber/v2/middleware/encryptcookie"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
+	"github.com/gofiber/fiber/v2/middleware/pprof"
 	"github.com/gofiber/fiber/v2/middleware/session"
 	"github.com/gofiber/template/django/v3"
-	"golang.org/x/crypto/bcrypt"
-	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
 // User model
 type User struct {
 	gorm.Model
-	Username string
-	Password string
+	Username string  `gorm:"uniqueIndex"` // stored normalized, see normalizeUsername
+	Password string  `json:"-"`
+	Email    *string `gorm:"uniqueIndex"` // NULL for accounts created before emails were collected
+	// EmailVerified is set by the /verify link; VerificationToken is the hash of the pending
+	// link's token, empty once used
+	EmailVerified     bool
+	VerificationToken string  `gorm:"index" json:"-"`
+	Interests         string  // comma-separated, see interestOptions
+	RateTier          string  `gorm:"default:free"`
+	Role              string  `gorm:"default:user"`
+	Labels            []Label `gorm:"many2many:user_labels"`
+	// LastLoginAt is NULL for accounts that haven't logged in since it was added
+	LastLoginAt *time.Time
+	// FailedLogins counts wrong passwords since the last login; LockedUntil is set while the
+	// account is locked because of them, see authenticate
+	FailedLogins int        `json:"-"`
+	LockedUntil  *time.Time `json:"-"`
+	// AvatarPath is the uploaded profile picture relative to STATIC_DIR, empty if there isn't one
+	AvatarPath string `json:"-"`
+	// TokenVersion is copied into the API tokens issued to the user, and raised to revoke them,
+	// see revokeAPITokens
+	TokenVersion int `json:"-"`
 }
 
+// Rate limit for POST /login, per IP, to slow down password guessing
+const (
+	loginAttemptsMax    = 5
+	loginAttemptsWindow = time.Minute
+)
+
+// How long a login with "remember me" ticked lasts. Other logins last SESSION_IDLE_MINUTES past
+// their latest request, see slideSession, and so do sessions without a login.
+const rememberMeLifetime = 30 * 24 * time.Hour
+
+// Rate limit for GET /api/v1/username-available, per IP
+const (
+	usernameCheckMax    = 20
+	usernameCheckWindow = time.Minute
+)
+
+// interestOptions are the choices offered on the preferences page
+var interestOptions = []string{"go", "web", "databases", "devops", "security"}
+
 func main() {
-	// register type for flash messages
-	gob.Register([]map[string]string{})
+	registerSessionTypes()
+
+	cfg := loadConfig()
+	setupLogging(cfg.LogFormat, cfg.LogLevel)
+	database := cfg.DBDriver
+	if cfg.DBDriver == dbDriverSQLite && cfg.DBDSN == "" {
+		database += " " + cfg.DBPath
+	}
+	slog.Info("starting", "port", cfg.Port, "database", database, "static_dir", cfg.StaticDir, "template_dir", cfg.TemplateDir)
 
 	// Initialize the HTML template engine
-	engine := django.New("./templates", ".html")
-	sessionStore := session.New() // Create session store within main
+	engine := django.New(cfg.TemplateDir, ".html")
+	if cfg.PrewarmTemplates {
+		// Fiber loads the templates too, but only warns on errors and carries on without them
+		if err := engine.Load(); err != nil {
+			log.Fatalf("Failed to load templates: %v", err)
+		}
+		slog.Info("templates loaded", "count", len(engine.Templates))
+	}
+
+	// Setup Database
+	db, err := openDB(cfg)
+	if err != nil {
+		log.Fatalf("failed to connect database: %v", err)
+	}
+	if err := registerQueryCounter(db); err != nil {
+		log.Fatalf("failed to register query counter: %v", err)
+	}
+	// A read-only database can't be migrated, but may still be served. Any other failure would
+	// leave a half-migrated schema that handlers trip over later, so it stops the server.
+	if err := migrate(db); err != nil {
+		if !isReadOnlyError(err) {
+			log.Fatalf("failed to migrate database: %v", err)
+		}
+		slog.Warn("database not migrated, it is read-only", "error", err)
+	}
+
+	if cfg.AdminUsername != "" {
+		found, err := bootstrapAdmin(db, cfg.AdminUsername)
+		if err != nil {
+			log.Fatalf("Failed to bootstrap admin: %v", err)
+		}
+		if !found {
+			slog.Warn("ADMIN_USERNAME doesn't exist, register it and restart to make it admin", "username", cfg.AdminUsername)
+		}
+	}
+
+	app, sessionStore, err := newApp(cfg, db, engine)
+	if err != nil {
+		log.Fatalf("failed to set up the app: %v", err)
+	}
+	maintenance := startSQLiteMaintenance(db, cfg.SQLiteMaintenanceInterval, cfg.SQLiteMaintenanceWindow)
+
+	// Start the Fiber application
+	go func() {
+		if err := app.Listen(":" + cfg.Port); err != nil {
+			log.Fatalf("server error: %v", err)
+		}
+	}()
+
+	// Wait for Ctrl+C or a SIGTERM from the container runtime
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	<-quit
+	slog.Info("shutting down")
+
+	// Stop taking requests first, then close what the handlers were using
+	subsystems := []subsystem{
+		{"http server", cfg.ShutdownHTTPTimeout, app.ShutdownWithContext},
+		{"session storage", cfg.ShutdownSessionsTimeout, func(context.Context) error {
+			return sessionStore.Storage.Close()
+		}},
+	}
+	if maintenance != nil {
+		subsystems = append(subsystems, subsystem{"sqlite maintenance", cfg.ShutdownDBTimeout, maintenance.Stop})
+	}
+	subsystems = append(subsystems, subsystem{"database", cfg.ShutdownDBTimeout, func(context.Context) error {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return err
+		}
+		return sqlDB.Close()
+	}})
+	shutdown(subsystems)
+}
+
+// newApp builds the Fiber app with its middleware and routes on an open, migrated database.
+// The session store is returned for shutdown, which closes its storage.
+func newApp(cfg Config, db *gorm.DB, views fiber.Views) (*fiber.App, *session.Store, error) {
+	sessionStorage, err := newSessionStorage(cfg, db)
+	if err != nil {
+		return nil, nil, fmt.Errorf("setting up session storage: %w", err)
+	}
+	sessionStore := session.New(session.Config{
+		Storage: sessionStorage,
+		// The CSRF token gives every visitor a session, so the default is short; logins get
+		// their own lifetime from saveSession
+		Expiration:   cfg.SessionIdleTimeout,
+		KeyGenerator: func() string { return secureToken(32) },
+		// Scoped to the app when it's under BASE_PATH
+		CookiePath:     cfg.BasePath,
+		CookieHTTPOnly: true,
+		CookieSecure:   secureCookies(cfg),
+	})
+
+	// Made before the app, since the error pages show flashes too
+	flash := NewFlashManager(sessionStore, cfg.FlashStorage, cfg.SessionSecret, cfg.HTMXFlashes)
 
 	// Create a Fiber app with the configured engine
-	app := fiber.New(fiber.Config{
-		Views:             engine,
+	appConfig := fiber.Config{
+		Views:             views,
 		PassLocalsToViews: true,
-	})
+		ErrorHandler:      errorHandler(flash),
+		// The banner isn't JSON, so it would be the one unparseable thing in the log
+		DisableStartupMessage: cfg.LogFormat == logFormatJSON,
+		// Only believe X-Forwarded-* headers from our own proxies
+		EnableTrustedProxyCheck: true,
+		TrustedProxies:          cfg.TrustedProxies,
+	}
+	if len(cfg.TrustedProxies) > 0 {
+		// Makes c.IP() the client's address rather than the proxy's
+		appConfig.ProxyHeader = fiber.HeaderXForwardedFor
+	}
+	app := fiber.New(appConfig)
+
+	// The base path comes first, so every later redirect and page can use it, then the request
+	// ID, so every later log line can include it
+	app.Use(withBasePath(cfg.BasePath))
+	app.Use(requestID())
+	app.Use(accessLogger(cfg.LogFormat))
+	if cfg.SuspiciousRequestAction != suspiciousOff {
+		app.Use(suspiciousRequests(cfg))
+	}
+	if cfg.ForceHTTPS {
+		app.Use(forceHTTPS(cfg.ForceHTTPSExempt))
+	}
+	if cfg.SlowRequestThreshold > 0 {
+		app.Use(slowRequestLogger(cfg.SlowRequestThreshold))
+	}
+
+	// Covers static files and pages alike. Responses that already have a Content-Encoding,
+	// and types that don't compress such as images, are passed through untouched.
+	app.Use(compress.New(compress.Config{Level: cfg.CompressLevel}))
 
-	app.Use(logger.New())
+	// Everything else is served under BASE_PATH, which is empty unless a proxy serves the app
+	// from a sub-path
+	router := app.Group(cfg.BasePath)
 
 	// Serve static files
-	app.Static("/static", "./static")
+	router.Static("/static", cfg.StaticDir)
 
-	// Setup Database
-	db, err := gorm.Open(sqlite.Open("site.db"), &gorm.Config{})
-	if err != nil {
-		log.Fatalf("failed to connect database: %v", err)
+	// Before the session and user middlewares, so health checks stay cheap and sessionless
+	router.Get("/health", healthCheck(db))
+
+	// Also ahead of the session middlewares, so preflights are answered without a session lookup
+	if len(cfg.CORSOrigins) > 0 {
+		router.Use("/api", apiCORS(cfg.CORSOrigins))
 	}
-	// Migrate the schema
-	db.AutoMigrate(&User{})
 
-	app.Use(authMiddleware(sessionStore, db))
+	if cfg.SessionSecret != "" {
+		// Encrypt the session cookie so its ID can't be read or forged without the secret.
+		// The flash cookie is signed separately and doesn't need it.
+		router.Use(encryptcookie.New(encryptcookie.Config{
+			Key:    cookieEncryptionKey(cfg.SessionSecret),
+			Except: []string{flashCookieName},
+		}))
+	}
+
+	router.Use(replaceUnknownSessions(sessionStore, cfg))
+	router.Use(csrfProtection(sessionStore, secureCookies(cfg)))
+	router.Use(loadUser(sessionStore, flash, db, cfg))
 
 	// Setup routes
-	setupRoutes(app, db, sessionStore)
+	setupRoutes(router, db, sessionStore, flash, cfg)
+	return app, sessionStore, nil
 
-	// Start the Fiber application
-	log.Fatal(app.Listen(":3000"))
 }
 
-func setupRoutes(app *fiber.App, db *gorm.DB, sessionStore *session.Store) {
-	app.Get("/", func(c *fiber.Ctx) error {
-		return c.Render("index", prepareTemplateData(c, nil, sessionStore))
-	})
+// cookieEncryptionKey derives the AES-256 key for encryptcookie from SESSION_SECRET. The
+// prefix keeps it distinct from the flash cookie's HMAC key, which uses the secret as is.
+func cookieEncryptionKey(secret string) string {
+	sum := sha256.Sum256([]byte("cookie-encryption:" + secret))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
 
-	app.Get("/register", func(c *fiber.Ctx) error {
-		if getCurrentUser(c, sessionStore, db) != nil {
-			flash(c, "Cannot register, please logout first", "danger", sessionStore)
-			return c.Redirect("/")
-		}
-		return c.Render("register", prepareTemplateData(c, nil, sessionStore))
-	})
+// registerSessionTypes registers every non-basic type stored in a session with gob, which the
+// session store uses to encode values. An unregistered type makes saving the session fail at
+// runtime, so anything new put into a session has to be added here. Flash messages are the
+// exception, NewFlashManager registers their type itself.
+func registerSessionTypes() {
+	gob.Register(deviceFingerprint{})   // device binding of logged-in sessions
+	gob.Register(map[string][]string{}) // one-time form nonces
+}
 
-	app.Get("/login", func(c *fiber.Ctx) error {
-		if getCurrentUser(c, sessionStore, db) != nil {
-			flash(c, "Already logged in", "danger", sessionStore)
-			return c.Redirect("/")
-		}
-		return c.Render("login", prepareTemplateData(c, nil, sessionStore))
-	})
+// newSessionStorage returns the backend selected by SESSION_STORAGE; nil means Fiber's in-memory
+// default. Memory is the fastest and needs no table, but a restart logs everyone out and each
+// instance has its own sessions. "sql" keeps them in the app database, so they survive restarts
+// and are shared between instances, at the cost of a query per request and a write per save.
+// Either way the store is closed during shutdown, after the HTTP server has stopped.
+func newSessionStorage(cfg Config, db *gorm.DB) (fiber.Storage, error) {
+	switch cfg.SessionStorage {
+	case "memory":
+		return nil, nil
+	case "sql":
+		return newSQLStorage(db, cfg.SessionCleanupInterval, cfg.SessionHashIDs)
+	default:
+		return nil, fmt.Errorf("unknown SESSION_STORAGE %q", cfg.SessionStorage)
+	}
+}
 
-	app.Post("/register", func(c *fiber.Ctx) error {
-		// Parse the form
-		var data struct {
-			Username string `form:"username"`
-			Password string `form:"password"`
-		}
-		if err := c.BodyParser(&data); err != nil {
-			return err
-		}
+func setupRoutes(app fiber.Router, db *gorm.DB, sessionStore *session.Store, flash *FlashManager, cfg Config) {
+	h := newHandlers(db, sessionStore, flash, cfg)
+
+	app.Get("/", h.index)
+	app.Get("/register", h.showRegister)
+	app.Get("/login", h.showLogin)
+	app.Post("/register", h.register)
+
+	// c.IP() is the client's address from X-Forwarded-For when behind a trusted proxy
+	app.Post("/login", limiter.New(limiter.Config{
+		Max:        loginAttemptsMax,
+		Expiration: loginAttemptsWindow,
+		LimitReached: func(c *fiber.Ctx) error {
+			flash.Add(c, "Too many login attempts, please wait a minute and try again", "danger")
+			c.Status(fiber.StatusTooManyRequests)
+			return h.renderLogin(c)
+		},
+	}), h.login)
+
+	loggedIn := requireLogin(flash)
+	app.Get("/profile", loggedIn, h.profile)
+	app.Post("/profile/avatar", loggedIn, h.uploadAvatar)
+	app.Get("/change-password", loggedIn, h.showChangePassword)
+	app.Post("/change-password", loggedIn, h.changePassword)
+	app.Get("/preferences", loggedIn, h.showPreferences)
+	app.Post("/preferences", loggedIn, h.savePreferences)
+	app.Post("/logout", h.logout)
+	app.Post("/logout-all", loggedIn, h.logoutAll)
+	app.Post("/delete-account", loggedIn, h.deleteAccount)
+
+	h.setupEmailVerification(app, loggedIn)
+
+	if cfg.SecurityQuestions {
+		h.setupSecurityQuestions(app, loggedIn)
+	}
 
-		// check length of username and password
-		if len(data.Username) < 5 || len(data.Password) < 5 {
-			flash(c, "Username and Password must be 5 characters or greater", "danger", sessionStore)
-			return c.Render("register", prepareTemplateData(c, nil, sessionStore))
-		}
+	admin := app.Group("/admin", requireAdmin())
+	admin.Get("/", h.adminIndex)
+	admin.Get("/debug/info", h.debugInfo)
+	admin.Get("/stats/registrations", h.registrationStats)
+	admin.Get("/labels", h.listLabels)
+	admin.Post("/labels", h.createLabel)
+	admin.Put("/users/:id/labels/:label", h.assignLabel)
+	admin.Delete("/users/:id/labels/:label", h.removeLabel)
+	// PUT for API clients, POST for the form on the admin page
+	admin.Put("/users/:id/tier", h.setRateTier)
+	admin.Post("/users/:id/tier", h.setRateTier)
+	if cfg.DebugPprof {
+		// Serves /admin/debug/pprof/*, behind the same admin check
+		admin.Use(pprof.New(pprof.Config{Prefix: cfg.BasePath + "/admin"}))
+	}
 
-		// Check if username already exists
-		var user User
-		result := db.Where("username = ?", data.Username).First(&user)
-		if result.Error == nil {
-			flash(c, "User already exists", "danger", sessionStore)
-			return c.Render("register", prepareTemplateData(c, nil, sessionStore))
-		}
+	// Bearer tokens are checked first, so the limiter can find the token's user and their tier
+	app.Use("/api", apiAuthMiddleware(db, h.tokens), apiRateLimiter(cfg))
 
-		// Hash the password
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(data.Password), bcrypt.DefaultCost)
-		if err != nil {
-			return err
+	// Token login for programmatic clients, limited like the form login
+	app.Post("/api/login", limiter.New(limiter.Config{
+		Max:          loginAttemptsMax,
+		Expiration:   loginAttemptsWindow,
+		LimitReached: limitReachedJSON,
+	}), h.apiLogin)
+	// Registration for programmatic clients, which then log in for a token
+	app.Post("/api/register", h.apiRegister)
+
+	api := app.Group("/api/v1")
+
+	if cfg.APIDocs {
+		api.Get("/openapi.json", serveOpenAPISpec)
+		app.Get("/api/docs", func(c *fiber.Ctx) error {
+			return c.Render("api_docs", fiber.Map{})
+		})
+	}
+
+	api.Get("/me/permissions", requireAuth(), h.myPermissions)
+
+	// Limit lookups per IP so the endpoint can't be used to enumerate accounts quickly
+	api.Get("/username-available", limiter.New(limiter.Config{
+		Max:          usernameCheckMax,
+		Expiration:   usernameCheckWindow,
+		LimitReached: limitReachedJSON,
+	}), h.usernameAvailable)
+
+	// The users list is opt-in; when disabled the route is never registered and Fiber answers 404
+	if cfg.EnableUsersAPI {
+		// A separate, stricter quota than the general API limiter, shared by both paths, so the
+		// list can't be scraped by polling it
+		listingQuota := func(c *fiber.Ctx) error { return c.Next() }
+		if cfg.UsersAPIQuota > 0 {
+			listingQuota = limiter.New(limiter.Config{
+				Max:        cfg.UsersAPIQuota,
+				Expiration: cfg.UsersAPIQuotaWindow,
+				KeyGenerator: func(c *fiber.Ctx) string {
+					return strconv.FormatUint(uint64(currentUser(c).ID), 10)
+				},
+				LimitReached: limitReachedJSON,
+			})
 		}
 
-		// Create new user with hashed password
-		newUser := User{Username: data.Username, Password: string(hashedPassword)}
-		db.Create(&newUser)
+		// Admins can list users with a session or an API token from /api/login. Fetching one
+		// user isn't scraping, so it only has the general API limits.
+		api.Get("/users", requireAdmin(), listingQuota, h.listUsers)
+		api.Get("/users/:id", requireAdmin(), h.getUser)
+		// Unversioned paths kept alongside for existing clients
+		app.Get("/api/users", requireAdmin(), listingQuota, h.listUsers)
+		app.Get("/api/users/:id", requireAdmin(), h.getUser)
+	}
 
-		flash(c, "Registration successful!", "success", sessionStore)
+	// Anything no route matched gets the 404 page; keep this last
+	app.Use(notFound)
+}
 
-		// Redirect to the homepage
-		return c.Redirect("/")
-	})
+// prepareTemplateData adds the pending flashes to a template's data
+func prepareTemplateData(c *fiber.Ctx, data fiber.Map, flash *FlashManager) fiber.Map {
+	if data == nil {
+		data = fiber.Map{}
+	}
 
-	app.Post("/login", func(c *fiber.Ctx) error {
-		var data struct {
-			Username string `form:"username"`
-			Password string `form:"password"`
-		}
-		if err := c.BodyParser(&data); err != nil {
-			return err
+	// Shown on error pages, so users can quote it when reporting a problem
+	data["RequestID"] = currentRequestID(c)
+
+	flashes := flash.Drain(c)
+	if len(flashes) > 0 {
+		// The flash area is part of the layout, which a partial response doesn't include
+		if flash.htmx && isHTMXPartial(c) {
+			if err := triggerFlashes(c, flashes); err != nil {
+				slog.Error("error encoding flashes for HX-Trigger", "error", err)
+			}
+		} else {
+			data["Flashes"] = flashes
 		}
+	}
+	return data
+}
 
-		// Retrieve user by username
-		var user User
-		if err := db.Where("username = ?", data.Username).First(&user).Error; err != nil {
-			flash(c, "Invalid username or password", "danger", sessionStore)
-			return c.Redirect("/login")
-		}
+// loadUser looks up the logged-in user from the session, for handlers through currentUser and
+// for templates as a UserView in the "user" local. It never blocks, use requireAuth for that.
+func loadUser(sessionStore *session.Store, flash *FlashManager, db *gorm.DB, cfg Config) fiber.Handler {
+	validator := newSessionValidator(cfg)
+	users := newUserLoader(db, cfg.DedupeUserLookups)
 
-		// Compare hashed password
-		err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(data.Password))
-		if err != nil {
-			flash(c, "Invalid username or password", "danger", sessionStore)
-			return c.Redirect("/login")
+	return func(c *fiber.Ctx) error {
+		// Static files and health checks never show the user, and without a session cookie
+		// there's no session to find one in
+		if skipsUserLookup(routePath(c)) || c.Cookies("session_id") == "" {
+			return c.Next()
 		}
 
-		// Create session and store only user_id
 		sess, err := sessionStore.Get(c)
 		if err != nil {
-			return err
+			slog.Error("error fetching session", "error", err)
+			return c.Next()
 		}
-		sess.Set("user_id", user.ID)
-		if err := sess.Save(); err != nil {
-			return err
+
+		userID := sess.Get("user_id")
+		if userID == nil {
+			return c.Next()
 		}
 
-		// Optionally set a cookie with session ID, though Fiber does this automatically
-		c.Cookie(&fiber.Cookie{
-			Name:     "session_id",
-			Value:    sess.ID(),
-			Expires:  time.Now().Add(1 * time.Hour),
-			HTTPOnly: true,
-		})
+		// logout forgets the user and carries on anonymously, telling them why if message is set
+		logout := func(reason, message string) error {
+			logSessionEvent(c, cfg, sessionDestroyed, reason, sess.ID(), userID)
+			if err := forgetSession(c.UserContext(), db, sess.ID()); err != nil {
+				slog.Error("error forgetting session", "error", err)
+			}
+			sess.Delete("user_id")
+			sess.Delete("expires_at")
+			sess.Delete("max_expires_at")
+			sess.Delete("tracked")
+			sess.Delete("fingerprint")
+			if err := sess.Save(); err != nil {
+				slog.Error("error saving session", "error", err)
+			}
+			if message != "" {
+				flash.Add(c, message, "warning")
+			}
+			return c.Next()
+		}
 
-		flash(c, "Login successful!", "success", sessionStore)
-		return c.Redirect("/")
-	})
+		// Logins from before lifetimes were stored have no expires_at and last as long as storage keeps them
+		if expiresAt, ok := sess.Get("expires_at").(int64); ok && time.Now().Unix() >= expiresAt {
+			return logout("expired", "Your session has expired, please log in again")
+		}
 
-	app.Get("/logout", func(c *fiber.Ctx) error {
-		if getCurrentUser(c, sessionStore, db) == nil {
-			flash(c, "Can't log out, user not logged in", "danger", sessionStore)
-			return c.Redirect("/")
+		// A session used from a different device than it was created on may have been stolen
+		if cfg.SessionBinding != sessionBindingOff {
+			fingerprint, _ := sess.Get("fingerprint").(deviceFingerprint)
+			if !fingerprint.matches(c, cfg.SessionBinding) {
+				slog.Debug("session fingerprint mismatch", "user_id", userID)
+				return logout("fingerprint_mismatch", "Your session has ended, please log in again")
+			}
 		}
-		sess, err := sessionStore.Get(c)
-		if err != nil {
-			return err
+
+		// In SSO setups the session may have been revoked centrally
+		if validator != nil {
+			id, _ := userID.(uint)
+			if !validator.valid(c.UserContext(), sess.ID(), id) {
+				slog.Debug("session rejected by validation service", "user_id", userID)
+				return logout("revoked_upstream", "Your session has ended, please log in again")
+			}
 		}
-		// Destroy the session
-		sess.Destroy()
 
-		// Clear the cookie
-		c.ClearCookie("session_id")
+		// /logout-all ends sessions by removing their record. If the record can't be checked the
+		// session is kept, rather than logging everyone out while the database struggles.
+		if tracked, _ := sess.Get("tracked").(bool); tracked {
+			id, _ := userID.(uint)
+			recorded, err := sessionRecorded(c.UserContext(), db, id, sess.ID())
+			if err != nil {
+				slog.Error("error checking session record", "error", err)
+			} else if !recorded {
+				return logout("logout_all", "Your session has ended, please log in again")
+			}
+		}
 
-		flash(c, "Logout successful", "success", sessionStore)
-		return c.Redirect("/")
-	})
+		user, err := users.load(c.UserContext(), userID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// The account behind this session is gone
+			slog.Debug("user not found", "user_id", userID)
+			return logout("user_not_found", "")
+		}
+		if err != nil {
+			// Like the logout-all check, a struggling database mustn't log everyone out, so the
+			// session is kept for when the lookup works again
+			slog.Error("error loading the logged-in user", "user_id", userID, "error", err)
+			return fiber.NewError(fiber.StatusServiceUnavailable, "Service unavailable, please try again shortly")
+		}
 
-	app.Get("/api/users", func(c *fiber.Ctx) error {
-		var users []User
-		db.Find(&users)
-		return c.JSON(users)
-	})
+		setCurrentUser(c, &user)
+		slideSession(sess, cfg.SessionIdleTimeout)
+		// Once per page, not on the redirects and partials in between
+		if user.needsVerification() && c.Method() == fiber.MethodGet && wantsHTML(c) && !isHTMXPartial(c) && routePath(c) != "/verify" {
+			flash.Add(c, "Please verify your email address, the link is in the email we sent you", "warning")
+		}
+		return c.Next()
+	}
 }
 
-func prepareTemplateData(c *fiber.Ctx, data fiber.Map, sessionStore *session.Store) fiber.Map {
-	if data == nil {
-		data = fiber.Map{}
+// slideSession pushes a logged-in session's expiry to idle from now, capped at the login's
+// max_expires_at. Remembered logins have no cap and keep their fixed expiry. It saves the
+// session either way, since the CSRF middleware has already saved it with the store's
+// default expiry, and the session mustn't be used afterwards.
+func slideSession(sess *session.Session, idle time.Duration) {
+	if maxExpiresAt, ok := sess.Get("max_expires_at").(int64); ok {
+		sess.Set("expires_at", min(time.Now().Add(idle).Unix(), maxExpiresAt))
 	}
-
-	// Load session
-	sess, err := sessionStore.Get(c)
-	if err != nil {
-		log.Println("Error fetching session:", err)
-		return data
+	if err := saveSession(sess); err != nil {
+		slog.Error("error saving session", "error", err)
 	}
+}
 
-	// Retrieve and include flashes if available
-	flashes := sess.Get("flashes")
-	if flashes != nil {
-		data["Flashes"] = flashes
-		sess.Delete("flashes") // Optionally clear flashes after loading
-		sess.Save()
+// saveSession saves sess, keeping a logged-in session's storage entry and cookie until its
+// expires_at. Fiber's store would otherwise give it the default expiry meant for anonymous
+// sessions. Like Save, it hands the session back to Fiber's pool.
+func saveSession(sess *session.Session) error {
+	if expiresAt, ok := sess.Get("expires_at").(int64); ok {
+		sess.SetExpiry(time.Until(time.Unix(expiresAt, 0)))
 	}
+	return sess.Save()
+}
 
-	return data
+// secureCookies reports whether cookies should only be sent over HTTPS: when HTTP is
+// redirected to HTTPS anyway, and always in production
+func secureCookies(cfg Config) bool {
+	return cfg.ForceHTTPS || cfg.AppEnv == "production"
 }
 
-func flash(c *fiber.Ctx, message string, category string, sessionStore *session.Store) error {
-	sess, err := sessionStore.Get(c)
-	if err != nil {
-		return err
-	}
-	var flashes []map[string]string
-	if f := sess.Get("flashes"); f != nil {
-		flashes = f.([]map[string]string)
-	} else {
-		flashes = make([]map[string]string, 0) // Explicit initialization
-	}
-	flashes = append(flashes, map[string]string{"message": message, "category": category})
-	sess.Set("flashes", flashes)
-	if err := sess.Save(); err != nil {
-		log.Println("Error saving session:", err)
-	}
-	return err
+// setCurrentUser makes user the logged-in user for the rest of the request
+func setCurrentUser(c *fiber.Ctx, user *User) {
+	c.Locals("currentUser", user)
+	c.Locals("user", user.ToView())
+	// Templates check these with e.g. {% if "stats:view" in permissions %}
+	c.Locals("permissions", permissionsFor(user.Role))
 }
 
-func authMiddleware(sessionStore *session.Store, db *gorm.DB) fiber.Handler {
+// replaceUnknownSessions gives a request whose session cookie matches no stored session, because
+// it expired or was never issued, a new session ID. Fiber's store would otherwise save the
+// next session under the ID the client picked, and the CSRF middleware saves one on every page.
+// It runs first of the session middlewares, so it's also where expired sessions are logged.
+func replaceUnknownSessions(sessionStore *session.Store, cfg Config) fiber.Handler {
 	return func(c *fiber.Ctx) error {
+		if skipsUserLookup(routePath(c)) || c.Cookies("session_id") == "" {
+			return c.Next()
+		}
 		sess, err := sessionStore.Get(c)
 		if err != nil {
-			log.Println("Error fetching session:", err)
-			return c.SendStatus(fiber.StatusInternalServerError)
+			slog.Error("error fetching session", "error", err)
+			return c.Next()
 		}
-
-		userID := sess.Get("user_id")
-		if userID == nil {
-			log.Println("user_id is nil")
+		if !sess.Fresh() {
 			return c.Next()
 		}
-
-		var user User
-		if err := db.First(&user, userID).Error; err != nil {
-			log.Println("User not found:", err)
-			return c.SendStatus(fiber.StatusUnauthorized)
+		logSessionEvent(c, cfg, sessionDestroyed, "expired", sess.ID(), nil)
+		if err := sess.Regenerate(); err != nil {
+			return err
 		}
-		log.Println("User found and set")
-
-		c.Locals("user", &user)
+		// Later lookups in this request read the ID from the request cookie
+		c.Request().Header.SetCookie("session_id", sess.ID())
 		return c.Next()
 	}
 }
 
-func getCurrentUser(c *fiber.Ctx, sessionStore *session.Store, db *gorm.DB) *User {
-	// Retrieve the session using the Fiber context
-	sess, err := sessionStore.Get(c)
-	if err != nil {
-		log.Println("Error fetching session:", err)
-		return nil
-	}
+// skipsUserLookup reports whether loadUser can leave path alone. Most of these are answered
+// before loadUser runs; this covers the rest, such as missing static files.
+func skipsUserLookup(path string) bool {
+	return path == "/health" || path == "/static" || strings.HasPrefix(path, "/static/")
+}
 
-	// Attempt to retrieve user_id from the session
-	userID, ok := sess.Get("user_id").(uint)
-	if !ok {
-		return nil // No user_id found, or type assertion failed
+// requireLogin sends visitors without a logged-in user to /login, see redirectToLogin. It's the
+// page counterpart of requireAuth, so the handlers behind it can rely on currentUser.
+func requireLogin(flash *FlashManager) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if currentUser(c) == nil {
+			return redirectToLogin(c, flash)
+		}
+		return c.Next()
 	}
+}
 
-	// Retrieve the user from the database based on user_id
-	var user User
-	if err := db.First(&user, userID).Error; err != nil {
-		log.Println("User not found:", err)
-		return nil
+// requireAuth rejects requests without a logged-in user; it relies on loadUser having run first
+func requireAuth() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if currentUser(c) == nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+		}
+		return c.Next()
 	}
-
-	return &user
 }
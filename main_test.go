@@ -0,0 +1,582 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/session"
+	"github.com/gofiber/template/django/v3"
+	"github.com/valyala/fasthttp"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testServer is the whole app on a throwaway in-memory database, with a cookie jar so requests
+// carry the session like a browser's would
+type testServer struct {
+	t       *testing.T
+	app     *fiber.App
+	db      *gorm.DB
+	cookies map[string]string
+}
+
+// newTestServer builds the app the way main does, from the default configuration with each
+// test's own shared-cache in-memory SQLite database, so tests don't see each other's users
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	registerSessionTypes()
+	cfg := loadConfig()
+	cfg.DBDriver = dbDriverSQLite
+	cfg.DBDSN = "file:" + url.PathEscape(t.Name()) + "?mode=memory&cache=shared"
+	db, err := openDB(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Logger = logger.Default.LogMode(logger.Silent)
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	if err := migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	app, _, err := newApp(cfg, db, django.New("./templates", ".html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testServer{t: t, app: app, db: db, cookies: map[string]string{}}
+}
+
+// do sends req with the jar's cookies and keeps the ones the response sets or clears
+func (s *testServer) do(req *http.Request) *http.Response {
+	s.t.Helper()
+	for name, value := range s.cookies {
+		req.AddCookie(&http.Cookie{Name: name, Value: value})
+	}
+	resp, err := s.app.Test(req, -1)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	for _, cookie := range resp.Cookies() {
+		if cookie.Value == "" || (!cookie.Expires.IsZero() && cookie.Expires.Before(time.Now())) {
+			delete(s.cookies, cookie.Name)
+		} else {
+			s.cookies[cookie.Name] = cookie.Value
+		}
+	}
+	return resp
+}
+
+// get fetches path and returns the response and its body
+func (s *testServer) get(path string) (*http.Response, string) {
+	s.t.Helper()
+	resp := s.do(httptest.NewRequest(fiber.MethodGet, path, nil))
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+var hiddenFieldPattern = regexp.MustCompile(`<input type="hidden" name="([^"]+)" value="([^"]*)">`)
+
+// submit loads the form on page and posts form to action along with the page's hidden fields,
+// such as the CSRF token, the way a browser would
+func (s *testServer) submit(page, action string, form url.Values) *http.Response {
+	s.t.Helper()
+	_, body := s.get(page)
+	for _, match := range hiddenFieldPattern.FindAllStringSubmatch(body, -1) {
+		if !form.Has(match[1]) {
+			form.Set(match[1], match[2])
+		}
+	}
+	if !form.Has(csrfFormField) {
+		s.t.Fatalf("no CSRF token on %s", page)
+	}
+	req := httptest.NewRequest(fiber.MethodPost, action, strings.NewReader(form.Encode()))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+	return s.do(req)
+}
+
+// expectRedirect fails the test unless resp redirects to location
+func expectRedirect(t *testing.T, step string, resp *http.Response, location string) {
+	t.Helper()
+	if resp.StatusCode != fiber.StatusFound || resp.Header.Get(fiber.HeaderLocation) != location {
+		t.Fatalf("%s: got %d to %q, want a redirect to %q", step, resp.StatusCode, resp.Header.Get(fiber.HeaderLocation), location)
+	}
+}
+
+func TestPagesRequireLogin(t *testing.T) {
+	t.Setenv("SECURITY_QUESTIONS", "true")
+	s := newTestServer(t)
+	token := s.hiddenFields("/login").Get(csrfFormField)
+
+	for _, path := range []string{"/profile", "/change-password", "/preferences", "/profile/security"} {
+		resp, _ := s.get(path)
+		expectRedirect(t, "GET "+path, resp, "/login?next="+url.QueryEscape(path))
+	}
+	// A form post can't be replayed after logging in, so it isn't returned to
+	for _, path := range []string{"/profile/avatar", "/change-password", "/preferences", "/logout-all", "/delete-account", "/profile/security", "/verify/resend"} {
+		req := httptest.NewRequest(fiber.MethodPost, path, nil)
+		req.Header.Set(csrfHeader, token)
+		expectRedirect(t, "POST "+path, s.do(req), "/login")
+	}
+}
+
+func TestAuthFlow(t *testing.T) {
+	s := newTestServer(t)
+
+	// Protected pages send visitors to log in, and back afterwards
+	resp, _ := s.get("/profile")
+	expectRedirect(t, "profile before login", resp, "/login?next=%2Fprofile")
+
+	resp = s.submit("/register", "/register", url.Values{
+		"username": {"alice"},
+		"email":    {"alice@example.com"},
+		"password": {"secret123"},
+	})
+	expectRedirect(t, "register", resp, "/")
+
+	resp = s.submit("/login", "/login", url.Values{"username": {"alice"}, "password": {"wrong password1"}})
+	expectRedirect(t, "login with the wrong password", resp, "/login")
+	// The failed login's flash and the CSRF token live in an anonymous session
+	anonymous := s.cookies["session_id"]
+
+	// The login form carries ?next= through as a hidden field
+	resp = s.submit("/login?next=/profile", "/login", url.Values{"username": {"alice"}, "password": {"secret123"}})
+	expectRedirect(t, "login", resp, "/profile")
+	loggedIn := s.cookies["session_id"]
+	if loggedIn == "" {
+		t.Fatal("no session cookie after logging in")
+	}
+	if loggedIn == anonymous {
+		t.Error("login kept the session ID from before logging in")
+	}
+
+	// The session cookie from the login is what makes this request alice's
+	resp, body := s.get("/profile")
+	if resp.StatusCode != fiber.StatusOK || !strings.Contains(body, "alice") {
+		t.Fatalf("profile after login: got %d, want alice's profile", resp.StatusCode)
+	}
+	if !strings.Contains(body, "Login successful!") {
+		t.Error("the login flash didn't reach the new session")
+	}
+
+	// Someone who planted the anonymous ID isn't logged in with it
+	s.cookies["session_id"] = anonymous
+	resp, _ = s.get("/profile")
+	expectRedirect(t, "profile with the pre-login cookie", resp, "/login?next=%2Fprofile")
+	s.cookies["session_id"] = loggedIn
+
+	resp = s.submit("/profile", "/logout", url.Values{})
+	expectRedirect(t, "logout", resp, "/")
+	// The "Logout successful" flash starts a new, anonymous session
+	if s.cookies["session_id"] == loggedIn {
+		t.Error("logout kept the logged-in session cookie")
+	}
+	resp, _ = s.get("/profile")
+	expectRedirect(t, "profile after logout", resp, "/login?next=%2Fprofile")
+
+	// The session is gone on the server too, so a copy of the old cookie is no use
+	s.cookies["session_id"] = loggedIn
+	resp, _ = s.get("/profile")
+	expectRedirect(t, "profile with the logged-out cookie", resp, "/login?next=%2Fprofile")
+}
+
+// sessionCookie returns the session cookie resp sets, failing the test if there isn't one
+func sessionCookie(t *testing.T, resp *http.Response) *http.Cookie {
+	t.Helper()
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "session_id" {
+			return cookie
+		}
+	}
+	t.Fatal("no session cookie in the response")
+	return nil
+}
+
+func TestSessionCookieAttributes(t *testing.T) {
+	tests := []struct {
+		name       string
+		remember   bool
+		wantMaxAge time.Duration
+	}{
+		{"sliding login", false, time.Hour},
+		{"remembered login", true, rememberMeLifetime},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("APP_ENV", "production")
+			t.Setenv("SESSION_SECRET", strings.Repeat("s", minSessionSecretLength))
+			t.Setenv("JWT_SECRET", strings.Repeat("j", minSessionSecretLength))
+			t.Setenv("PUBLIC_URL", "https://example.com")
+			s := newTestServer(t)
+			createTestUser(t, s.db, "alice", "secret123")
+			form := url.Values{"username": {"alice"}, "password": {"secret123"}}
+			if tt.remember {
+				form.Set("remember", "on")
+			}
+			expectRedirect(t, "login", s.submit("/login", "/login", form), "/")
+
+			// Every page saves the session for its CSRF token, which sends the cookie again
+			resp, _ := s.get("/profile")
+			cookie := sessionCookie(t, resp)
+			if !cookie.HttpOnly || !cookie.Secure {
+				t.Errorf("session cookie HttpOnly %v, Secure %v, want both", cookie.HttpOnly, cookie.Secure)
+			}
+			if maxAge := time.Duration(cookie.MaxAge) * time.Second; maxAge > tt.wantMaxAge || maxAge < tt.wantMaxAge-time.Minute {
+				t.Errorf("session cookie lasts %v, want %v", maxAge, tt.wantMaxAge)
+			}
+		})
+	}
+}
+
+func TestExpiredSessionIsLogged(t *testing.T) {
+	t.Setenv("SESSION_STORAGE", "sql")
+	s := newTestServer(t)
+	createTestUser(t, s.db, "alice", "secret123")
+	expectRedirect(t, "login", s.submit("/login", "/login", url.Values{"username": {"alice"}, "password": {"secret123"}}), "/")
+
+	// The storage dropping the session is what expiry looks like to the app
+	if err := s.db.Where("1 = 1").Delete(&SessionData{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	logs := captureLogs(t)
+	resp, _ := s.get("/profile")
+	expectRedirect(t, "profile with the expired session", resp, "/login?next=%2Fprofile")
+	if !strings.Contains(logs.String(), "event=session_destroyed reason=expired") {
+		t.Errorf("no expiry event logged, got:\n%s", logs)
+	}
+}
+
+func TestUnknownSessionIDIsReplaced(t *testing.T) {
+	s := newTestServer(t)
+	s.cookies["session_id"] = "chosen-by-the-client"
+
+	resp, _ := s.get("/login")
+	if cookie := sessionCookie(t, resp); cookie.Value == "chosen-by-the-client" {
+		t.Error("a session was saved under the ID the client sent")
+	}
+}
+
+func TestPostWithoutCSRFTokenIsRejected(t *testing.T) {
+	s := newTestServer(t)
+
+	req := httptest.NewRequest(fiber.MethodPost, "/register", strings.NewReader("username=alice&password=secret123"))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+	if resp := s.do(req); resp.StatusCode != fiber.StatusForbidden {
+		t.Errorf("register without a CSRF token = %d, want %d", resp.StatusCode, fiber.StatusForbidden)
+	}
+	var count int64
+	s.db.Model(&User{}).Count(&count)
+	if count != 0 {
+		t.Errorf("%d users created without a CSRF token", count)
+	}
+}
+
+func TestSlideSession(t *testing.T) {
+	const idle = 30 * time.Minute
+	tests := []struct {
+		name             string
+		expiresIn, maxIn time.Duration // maxIn 0 is a remembered login, without a cap
+		wantIn           time.Duration
+	}{
+		{"pushed back", 5 * time.Minute, 10 * time.Hour, idle},
+		{"capped", 5 * time.Minute, 20 * time.Minute, 20 * time.Minute},
+		{"just refreshed", idle - 10*time.Second, 10 * time.Hour, idle},
+		{"remembered", 5 * time.Minute, 0, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := session.New()
+			app := fiber.New()
+			app.Get("/start", func(c *fiber.Ctx) error {
+				sess, err := store.Get(c)
+				if err != nil {
+					return err
+				}
+				sess.Set("expires_at", time.Now().Add(tt.expiresIn).Unix())
+				if tt.maxIn != 0 {
+					sess.Set("max_expires_at", time.Now().Add(tt.maxIn).Unix())
+				}
+				return sess.Save()
+			})
+			app.Get("/slide", func(c *fiber.Ctx) error {
+				sess, err := store.Get(c)
+				if err != nil {
+					return err
+				}
+				slideSession(sess, idle)
+				return nil
+			})
+			var expiresAt int64
+			app.Get("/read", func(c *fiber.Ctx) error {
+				sess, err := store.Get(c)
+				if err != nil {
+					return err
+				}
+				expiresAt, _ = sess.Get("expires_at").(int64)
+				return nil
+			})
+
+			resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/start", nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			cookie := resp.Cookies()[0]
+			for _, path := range []string{"/slide", "/read"} {
+				req := httptest.NewRequest(fiber.MethodGet, path, nil)
+				req.AddCookie(cookie)
+				if _, err := app.Test(req); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want := time.Now().Add(tt.wantIn).Unix()
+			if expiresAt < want-2 || expiresAt > want+2 {
+				t.Errorf("expires in %v, want %v", time.Until(time.Unix(expiresAt, 0)).Round(time.Second), tt.wantIn)
+			}
+		})
+	}
+}
+
+func TestLoadUserKeepsSessionWhenLookupFails(t *testing.T) {
+	registerSessionTypes()
+	db := newTestDB(t)
+	alice := createTestUser(t, db, "alice", "secret123")
+	sessionStore := session.New()
+	flash := NewFlashManager(sessionStore, flashStorageSession, "", false)
+
+	app := fiber.New()
+	app.Get("/login", func(c *fiber.Ctx) error {
+		sess, err := sessionStore.Get(c)
+		if err != nil {
+			return err
+		}
+		sess.Set("user_id", alice.ID)
+		return sess.Save()
+	})
+	app.Use(loadUser(sessionStore, flash, db, Config{SessionBinding: sessionBindingOff}))
+	app.Get("/whoami", func(c *fiber.Ctx) error {
+		if user := currentUser(c); user != nil {
+			return c.SendString(user.Username)
+		}
+		return c.SendString("anonymous")
+	})
+
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/login", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := resp.Cookies()[0]
+	whoami := func() (int, string) {
+		t.Helper()
+		req := httptest.NewRequest(fiber.MethodGet, "/whoami", nil)
+		req.AddCookie(cookie)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	// Without the table the lookup fails with an error other than "not found"
+	if err := db.Migrator().RenameTable(&User{}, "users_away"); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := whoami(); status != fiber.StatusServiceUnavailable {
+		t.Errorf("failed lookup answered %d, want 503", status)
+	}
+	if err := db.Migrator().RenameTable("users_away", &User{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, body := whoami(); body != "alice" {
+		t.Errorf("after the database recovered the request was made as %q, want alice", body)
+	}
+
+	if err := db.Delete(&alice).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, body := whoami(); body != "anonymous" {
+		t.Errorf("the deleted user's session still logs in as %q", body)
+	}
+}
+
+func TestBearerRequestsUseTheTokenUsersRateTier(t *testing.T) {
+	t.Setenv("API_RATE_TIERS", "anonymous=1,free=1,premium=3")
+	s := newTestServer(t)
+	alice := createTestUser(t, s.db, "alice", "secret123")
+	if err := s.db.Model(&alice).Update("rate_tier", RateTierPremium).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(fiber.MethodPost, "/api/login", strings.NewReader(`{"username": "alice", "password": "secret123"}`))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	resp := s.do(req)
+	var login struct {
+		Token string `json:"token"`
+	}
+	decodeJSON(t, resp, &login)
+
+	// Anonymous and free allow a single request, so only the premium tier lets all of these through
+	for i := 1; i <= 3; i++ {
+		req := httptest.NewRequest(fiber.MethodGet, "/api/v1/me/permissions", nil)
+		req.Header.Set(fiber.HeaderAuthorization, bearerPrefix+login.Token)
+		if resp := s.do(req); resp.StatusCode != fiber.StatusOK {
+			t.Fatalf("request %d with alice's token answered %d, want 200", i, resp.StatusCode)
+		}
+	}
+}
+
+// apiToken logs in through /api/login and returns the bearer token
+func (s *testServer) apiToken(username, password string) string {
+	s.t.Helper()
+	req := httptest.NewRequest(fiber.MethodPost, "/api/login", strings.NewReader(`{"username": "`+username+`", "password": "`+password+`"}`))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	var login struct {
+		Token string `json:"token"`
+	}
+	decodeJSON(s.t, s.do(req), &login)
+	return login.Token
+}
+
+func TestAPITokensRevoked(t *testing.T) {
+	s := newTestServer(t)
+	alice := createTestUser(t, s.db, "alice", "secret123")
+	status := func(token string) int {
+		req := httptest.NewRequest(fiber.MethodGet, "/api/v1/me/permissions", nil)
+		req.Header.Set(fiber.HeaderAuthorization, bearerPrefix+token)
+		return s.do(req).StatusCode
+	}
+
+	token := s.apiToken("alice", "secret123")
+	if got := status(token); got != fiber.StatusOK {
+		t.Fatalf("fresh token answered %d", got)
+	}
+	expectRedirect(t, "login", s.submit("/login", "/login", url.Values{"username": {"alice"}, "password": {"secret123"}}), "/")
+	resp := s.submit("/change-password", "/change-password", url.Values{
+		"current_password": {"secret123"}, "new_password": {"secret456"}, "confirm_password": {"secret456"},
+	})
+	expectRedirect(t, "change password", resp, "/profile")
+	if got := status(token); got != fiber.StatusUnauthorized {
+		t.Errorf("token from before the password change answered %d, want 401", got)
+	}
+
+	token = s.apiToken("alice", "secret456")
+	expectRedirect(t, "logout everywhere", s.submit("/profile", "/logout-all", url.Values{}), "/login")
+	if got := status(token); got != fiber.StatusUnauthorized {
+		t.Errorf("token from before logging out everywhere answered %d, want 401", got)
+	}
+
+	token = s.apiToken("alice", "secret456")
+	if err := s.db.Model(&alice).Update("locked_until", time.Now().Add(time.Hour)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if got := status(token); got != fiber.StatusUnauthorized {
+		t.Errorf("token for a locked account answered %d, want 401", got)
+	}
+}
+
+func TestUsersAPIAccess(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		t.Run("enabled="+strconv.FormatBool(enabled), func(t *testing.T) {
+			t.Setenv("ENABLE_USERS_API", strconv.FormatBool(enabled))
+			s := newTestServer(t)
+			createTestUser(t, s.db, "alice", "secret123")
+			admin := createTestUser(t, s.db, "carol", "secret123")
+			if err := s.db.Model(&admin).Update("role", RoleAdmin).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			tests := []struct {
+				name  string
+				token string
+				want  int
+			}{
+				{"anonymous", "", fiber.StatusUnauthorized},
+				{"user", s.apiToken("alice", "secret123"), fiber.StatusForbidden},
+				{"admin", s.apiToken("carol", "secret123"), fiber.StatusOK},
+			}
+			for _, tt := range tests {
+				// The listing is opt-in, so with it off nobody finds it
+				want := tt.want
+				if !enabled {
+					want = fiber.StatusNotFound
+				}
+				req := httptest.NewRequest(fiber.MethodGet, "/api/v1/users", nil)
+				if tt.token != "" {
+					req.Header.Set(fiber.HeaderAuthorization, bearerPrefix+tt.token)
+				}
+				if resp := s.do(req); resp.StatusCode != want {
+					t.Errorf("%s: answered %d, want %d", tt.name, resp.StatusCode, want)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkLoadUser measures what loadUser adds to requests that have no logged-in user:
+// static files and pages for visitors without a session cookie
+func BenchmarkLoadUser(b *testing.B) {
+	staticDir := b.TempDir()
+	if err := os.WriteFile(filepath.Join(staticDir, "style.css"), []byte("body { margin: 0 }"), 0o644); err != nil {
+		b.Fatal(err)
+	}
+	db := newTestDB(b)
+	sessionStore := session.New()
+	flash := NewFlashManager(sessionStore, flashStorageSession, "", false)
+
+	app := fiber.New()
+	app.Use(loadUser(sessionStore, flash, db, Config{}))
+	app.Static("/static", staticDir)
+	app.Get("/", func(c *fiber.Ctx) error { return c.SendString("home") })
+	handler := app.Handler()
+
+	for _, path := range []string{"/static/style.css", "/"} {
+		b.Run(path, func(b *testing.B) {
+			var ctx fasthttp.RequestCtx
+			for i := 0; i < b.N; i++ {
+				ctx.Request.Reset()
+				ctx.Response.Reset()
+				ctx.Request.SetRequestURI(path)
+				handler(&ctx)
+				if ctx.Response.StatusCode() != fiber.StatusOK {
+					b.Fatalf("GET %s = %d", path, ctx.Response.StatusCode())
+				}
+			}
+		})
+	}
+}
+
+func TestBasePath(t *testing.T) {
+	t.Setenv("BASE_PATH", "/myapp/")
+	s := newTestServer(t)
+
+	resp, body := s.get("/myapp/login")
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("login page under the base path = %d", resp.StatusCode)
+	}
+	if !strings.Contains(body, `href="/myapp/register"`) || !strings.Contains(body, `href="/myapp/static/css/style.css"`) {
+		t.Error("login page links don't include the base path")
+	}
+
+	// next stays a path within the app, and the redirect adds the base path once
+	resp, _ = s.get("/myapp/profile")
+	expectRedirect(t, "profile under the base path", resp, "/myapp/login?next=%2Fprofile")
+
+	if resp, _ := s.get("/profile"); resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("profile outside the base path = %d, want %d", resp.StatusCode, fiber.StatusNotFound)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// sqliteMaintenance periodically runs VACUUM and PRAGMA optimize on a SQLite database, which
+// otherwise never gives back the space freed by deleted rows
+type sqliteMaintenance struct {
+	db       *gorm.DB
+	interval time.Duration
+	window   maintenanceWindow
+	done     chan struct{}
+	stopped  chan struct{}
+}
+
+// maintenanceWindow is a range of local hours [Start, End) in which maintenance may run.
+// It may wrap past midnight, e.g. 22-4. The zero value allows any hour.
+type maintenanceWindow struct {
+	Start, End int
+}
+
+// startSQLiteMaintenance starts the job, or returns nil if it's disabled or the database isn't SQLite
+func startSQLiteMaintenance(db *gorm.DB, interval time.Duration, window maintenanceWindow) *sqliteMaintenance {
+	if interval <= 0 {
+		return nil
+	}
+	if db.Dialector.Name() != "sqlite" {
+		slog.Info("SQLite maintenance skipped", "driver", db.Dialector.Name())
+		return nil
+	}
+	m := &sqliteMaintenance{
+		db:       db,
+		interval: interval,
+		window:   window,
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go m.run()
+	return m
+}
+
+// Stop ends the job, waiting for a run in progress to finish
+func (m *sqliteMaintenance) Stop(ctx context.Context) error {
+	close(m.done)
+	select {
+	case <-m.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *sqliteMaintenance) run() {
+	defer close(m.stopped)
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case now := <-ticker.C:
+			if !m.window.contains(now.Hour()) {
+				continue
+			}
+			if err := m.vacuum(); err != nil {
+				slog.Error("SQLite maintenance failed", "error", err)
+			}
+		}
+	}
+}
+
+func (m *sqliteMaintenance) vacuum() error {
+	start := time.Now()
+	before, err := m.size()
+	if err != nil {
+		return err
+	}
+	if err := m.db.Exec("VACUUM").Error; err != nil {
+		return err
+	}
+	if err := m.db.Exec("PRAGMA optimize").Error; err != nil {
+		return err
+	}
+	after, err := m.size()
+	if err != nil {
+		return err
+	}
+	slog.Info("SQLite maintenance done", "elapsed", time.Since(start).Round(time.Millisecond), "reclaimed_bytes", before-after)
+	return nil
+}
+
+// size returns the database file size in bytes
+func (m *sqliteMaintenance) size() (int64, error) {
+	var pageCount, pageSize int64
+	if err := m.db.Raw("PRAGMA page_count").Scan(&pageCount).Error; err != nil {
+		return 0, err
+	}
+	if err := m.db.Raw("PRAGMA page_size").Scan(&pageSize).Error; err != nil {
+		return 0, err
+	}
+	return pageCount * pageSize, nil
+}
+
+func (w maintenanceWindow) contains(hour int) bool {
+	switch {
+	case w.Start == w.End:
+		return true
+	case w.Start < w.End:
+		return hour >= w.Start && hour < w.End
+	default:
+		return hour >= w.Start || hour < w.End
+	}
+}
+
+// parseMaintenanceWindow parses "start-end" in hours, e.g. "2-5"; an empty string allows any hour
+func parseMaintenanceWindow(s string) (maintenanceWindow, error) {
+	if s == "" {
+		return maintenanceWindow{}, nil
+	}
+	start, end, ok := strings.Cut(s, "-")
+	if !ok {
+		return maintenanceWindow{}, fmt.Errorf("expected start-end hours, got %q", s)
+	}
+	var w maintenanceWindow
+	var err error
+	if w.Start, err = strconv.Atoi(strings.TrimSpace(start)); err != nil || w.Start < 0 || w.Start > 23 {
+		return maintenanceWindow{}, fmt.Errorf("invalid start hour in %q", s)
+	}
+	if w.End, err = strconv.Atoi(strings.TrimSpace(end)); err != nil || w.End < 0 || w.End > 23 {
+		return maintenanceWindow{}, fmt.Errorf("invalid end hour in %q", s)
+	}
+	return w, nil
+}
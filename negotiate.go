@@ -0,0 +1,15 @@
+package main
+
+import "github.com/gofiber/fiber/v2"
+
+// wantsJSON reports whether the client prefers JSON over HTML, based on its Accept header.
+// Browsers and clients sending */* get HTML.
+func wantsJSON(c *fiber.Ctx) bool {
+	return c.Accepts(fiber.MIMETextHTML, fiber.MIMEApplicationJSON) == fiber.MIMEApplicationJSON
+}
+
+// wantsHTML reports whether the client prefers HTML over JSON. It's the reverse default of
+// wantsJSON: clients sending */* get JSON, so only browsers asking for pages get HTML.
+func wantsHTML(c *fiber.Ctx) bool {
+	return c.Accepts(fiber.MIMEApplicationJSON, fiber.MIMETextHTML) == fiber.MIMETextHTML
+}
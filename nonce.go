@@ -0,0 +1,52 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/session"
+)
+
+// maxFormNonces bounds how many unused nonces are kept per form, e.g. for several open tabs
+const maxFormNonces = 10
+
+// issueFormNonce creates a one-time nonce for the named form and remembers it in the session.
+// Unlike a CSRF token it is single use, so it catches the same form being submitted twice.
+func issueFormNonce(c *fiber.Ctx, sessionStore *session.Store, form string) (string, error) {
+	nonce := secureToken(16)
+
+	sess, err := sessionStore.Get(c)
+	if err != nil {
+		return "", err
+	}
+	nonces, _ := sess.Get("form_nonces").(map[string][]string)
+	if nonces == nil {
+		nonces = make(map[string][]string)
+	}
+	pending := append(nonces[form], nonce)
+	if len(pending) > maxFormNonces {
+		pending = pending[len(pending)-maxFormNonces:]
+	}
+	nonces[form] = pending
+	sess.Set("form_nonces", nonces)
+	return nonce, saveSession(sess)
+}
+
+// consumeFormNonce reports whether nonce was issued for the named form and not used yet,
+// and marks it as used
+func consumeFormNonce(c *fiber.Ctx, sessionStore *session.Store, form, nonce string) (bool, error) {
+	if nonce == "" {
+		return false, nil
+	}
+	sess, err := sessionStore.Get(c)
+	if err != nil {
+		return false, err
+	}
+	nonces, _ := sess.Get("form_nonces").(map[string][]string)
+	for i, pending := range nonces[form] {
+		if pending == nonce {
+			nonces[form] = append(nonces[form][:i], nonces[form][i+1:]...)
+			sess.Set("form_nonces", nonces)
+			return true, saveSession(sess)
+		}
+	}
+	return false, nil
+}
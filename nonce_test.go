@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/session"
+)
+
+func TestFormNonces(t *testing.T) {
+	registerSessionTypes()
+	sessionStore := session.New()
+	app := fiber.New()
+	app.Get("/issue/:form", func(c *fiber.Ctx) error {
+		nonce, err := issueFormNonce(c, sessionStore, c.Params("form"))
+		if err != nil {
+			return err
+		}
+		return c.SendString(nonce)
+	})
+	app.Get("/consume/:form", func(c *fiber.Ctx) error {
+		fresh, err := consumeFormNonce(c, sessionStore, c.Params("form"), c.Query("nonce"))
+		if err != nil {
+			return err
+		}
+		return c.SendString(strconv.FormatBool(fresh))
+	})
+
+	var cookie *http.Cookie
+	get := func(target string) string {
+		t.Helper()
+		req := httptest.NewRequest(fiber.MethodGet, target, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cookies := resp.Cookies(); len(cookies) > 0 {
+			cookie = cookies[0]
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	first := get("/issue/register")
+	// Enough newer nonces to push the first one out
+	for i := 0; i < maxFormNonces; i++ {
+		get("/issue/register")
+	}
+	latest := get("/issue/register")
+
+	tests := []struct {
+		name, form, nonce string
+		want              string
+	}{
+		{"fresh nonce", "register", latest, "true"},
+		{"same nonce again", "register", latest, "false"},
+		{"nonce for another form", "profile", get("/issue/register"), "false"},
+		{"no nonce", "register", "", "false"},
+		{"nonce pushed out by newer ones", "register", first, "false"},
+	}
+	for _, tt := range tests {
+		if got := get("/consume/" + tt.form + "?nonce=" + url.QueryEscape(tt.nonce)); got != tt.want {
+			t.Errorf("%s: consumed = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
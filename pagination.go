@@ -0,0 +1,46 @@
+package main
+
+import "github.com/gofiber/fiber/v2"
+
+// Page size for paginated listings
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+// pageParams is a page requested with ?page= and ?per_page=
+type pageParams struct {
+	Page    int
+	PerPage int
+}
+
+// parsePage reads ?page= and ?per_page=. Missing, invalid or out of range values fall back to
+// the first page and the default size rather than failing; per_page is capped at maxPerPage.
+func parsePage(c *fiber.Ctx) pageParams {
+	p := pageParams{Page: c.QueryInt("page", 1), PerPage: c.QueryInt("per_page", defaultPerPage)}
+	if p.Page < 1 {
+		p.Page = 1
+	}
+	if p.PerPage < 1 {
+		p.PerPage = defaultPerPage
+	}
+	if p.PerPage > maxPerPage {
+		p.PerPage = maxPerPage
+	}
+	return p
+}
+
+// Offset is the number of rows before the page
+func (p pageParams) Offset() int {
+	return (p.Page - 1) * p.PerPage
+}
+
+// envelope wraps a page of results with its position in the whole list
+func (p pageParams) envelope(data interface{}, total int64) fiber.Map {
+	return fiber.Map{
+		"data":     data,
+		"page":     p.Page,
+		"per_page": p.PerPage,
+		"total":    total,
+	}
+}
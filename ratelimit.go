@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
+)
+
+// Rate tiers map a user to an API quota, see Config.APIRateTiers
+const (
+	RateTierFree    = "free"
+	RateTierPremium = "premium"
+
+	// rateTierAnonymous applies to requests without a logged-in user
+	rateTierAnonymous = "anonymous"
+)
+
+// apiRateLimiter limits API requests per user according to their RateTier, and per IP
+// for anonymous requests. Each tier gets its own limiter since Fiber's limiter has a
+// fixed maximum.
+func apiRateLimiter(cfg Config) fiber.Handler {
+	limiters := make(map[string]fiber.Handler, len(cfg.APIRateTiers))
+	for tier, max := range cfg.APIRateTiers {
+		limiters[tier] = limiter.New(limiter.Config{
+			Max:        max,
+			Expiration: cfg.APIRateWindow,
+			KeyGenerator: func(c *fiber.Ctx) string {
+				if user := currentUser(c); user != nil {
+					return "user:" + strconv.FormatUint(uint64(user.ID), 10)
+				}
+				return "ip:" + c.IP()
+			},
+			LimitReached: limitReachedJSON,
+		})
+	}
+
+	return func(c *fiber.Ctx) error {
+		tier := rateTierAnonymous
+		if user := currentUser(c); user != nil {
+			tier = user.RateTier
+			// A tier that was removed from the config falls back to the default one
+			if _, ok := limiters[tier]; !ok {
+				tier = RateTierFree
+			}
+		}
+		return limiters[tier](c)
+	}
+}
+
+// userRateTiers lists the configured tiers a user can be given, in order
+func userRateTiers(cfg Config) []string {
+	var tiers []string
+	for tier := range cfg.APIRateTiers {
+		if tier != rateTierAnonymous {
+			tiers = append(tiers, tier)
+		}
+	}
+	slices.Sort(tiers)
+	return tiers
+}
+
+// setRateTier gives user :id the rate tier in the "tier" form or JSON field. The admin page
+// posts its form here and is sent back to the list; API clients get the new tier as JSON.
+func (h *Handlers) setRateTier(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 0)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid user ID"})
+	}
+	var data struct {
+		Tier string `json:"tier" form:"tier"`
+	}
+	if err := c.BodyParser(&data); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid body"})
+	}
+	if !slices.Contains(userRateTiers(h.cfg), data.Tier) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "unknown rate tier"})
+	}
+	if h.cfg.ReadOnly {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": readOnlyMessage})
+	}
+
+	result := h.db.WithContext(c.UserContext()).Model(&User{}).Where("id = ?", id).Update("rate_tier", data.Tier)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "user not found"})
+	}
+
+	if wantsHTML(c) {
+		h.flash.Add(c, "Rate tier updated", "success")
+		return redirect(c, "/admin")
+	}
+	return c.JSON(fiber.Map{"id": id, "rate_tier": data.Tier})
+}
+
+// limitReachedJSON answers API requests rejected by a limiter. Fiber's limiter has already set
+// Retry-After from the time left in the window, so it's repeated in the body for clients that
+// don't look at headers.
+func limitReachedJSON(c *fiber.Ctx) error {
+	body := fiber.Map{"error": "too many requests"}
+	if retryAfter, err := strconv.Atoi(string(c.Response().Header.Peek(fiber.HeaderRetryAfter))); err == nil {
+		body["retry_after"] = retryAfter
+	}
+	return c.Status(fiber.StatusTooManyRequests).JSON(body)
+}
+
+// parseRateTiers parses a list like "anonymous=30,free=60,premium=600" into requests per window
+func parseRateTiers(s string) (map[string]int, error) {
+	tiers := make(map[string]int)
+	for _, part := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate tier %q, expected name=max", part)
+		}
+		max, err := strconv.Atoi(value)
+		if err != nil || max <= 0 {
+			return nil, fmt.Errorf("invalid maximum for rate tier %q", name)
+		}
+		tiers[name] = max
+	}
+	for _, required := range []string{rateTierAnonymous, RateTierFree} {
+		if _, ok := tiers[required]; !ok {
+			return nil, fmt.Errorf("rate tier %q must be configured", required)
+		}
+	}
+	return tiers, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
+)
+
+func TestSetRateTier(t *testing.T) {
+	h := newTestHandlers(t, Config{APIRateTiers: map[string]int{rateTierAnonymous: 1, RateTierFree: 2, RateTierPremium: 3}})
+	alice := createTestUser(t, h.db, "alice", "secret123")
+	app := newTestApp(nil)
+	app.Put("/admin/users/:id/tier", h.setRateTier)
+
+	tests := []struct {
+		name, target, body string
+		want               int
+		wantTier           string
+	}{
+		{"invalid ID", "/admin/users/abc/tier", `{"tier": "premium"}`, fiber.StatusBadRequest, RateTierFree},
+		{"unknown tier", "/admin/users/1/tier", `{"tier": "platinum"}`, fiber.StatusBadRequest, RateTierFree},
+		{"anonymous tier", "/admin/users/1/tier", `{"tier": "anonymous"}`, fiber.StatusBadRequest, RateTierFree},
+		{"unknown user", "/admin/users/99/tier", `{"tier": "premium"}`, fiber.StatusNotFound, RateTierFree},
+		{"premium", "/admin/users/1/tier", `{"tier": "premium"}`, fiber.StatusOK, RateTierPremium},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(fiber.MethodPut, tt.target, strings.NewReader(tt.body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp := doRequest(t, app, req)
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s: answered %d, want %d", tt.name, resp.StatusCode, tt.want)
+		}
+		var user User
+		if err := h.db.First(&user, alice.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		if user.RateTier != tt.wantTier {
+			t.Errorf("%s: tier is %q, want %q", tt.name, user.RateTier, tt.wantTier)
+		}
+	}
+}
+
+func TestLimitReachedJSON(t *testing.T) {
+	app := fiber.New()
+	app.Use(limiter.New(limiter.Config{Max: 1, Expiration: time.Minute, LimitReached: limitReachedJSON}))
+	app.Get("/", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+
+	doRequest(t, app, httptest.NewRequest(fiber.MethodGet, "/", nil))
+	resp := doRequest(t, app, httptest.NewRequest(fiber.MethodGet, "/", nil))
+	if resp.StatusCode != fiber.StatusTooManyRequests {
+		t.Fatalf("second request answered %d, want 429", resp.StatusCode)
+	}
+	header, err := strconv.Atoi(resp.Header.Get(fiber.HeaderRetryAfter))
+	if err != nil || header <= 0 || header > 60 {
+		t.Fatalf("Retry-After = %q, want the seconds left in the window", resp.Header.Get(fiber.HeaderRetryAfter))
+	}
+	var body struct {
+		Error      string `json:"error"`
+		RetryAfter int    `json:"retry_after"`
+	}
+	decodeJSON(t, resp, &body)
+	if body.Error != "too many requests" || body.RetryAfter != header {
+		t.Errorf("body = %+v, want retry_after to match the header's %d", body, header)
+	}
+}
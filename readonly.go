@@ -0,0 +1,28 @@
+package main
+
+import "strings"
+
+// readOnlyMessage is flashed when a write is refused because the database can't accept it
+const readOnlyMessage = "The site is in read-only maintenance right now, please try again later"
+
+// isReadOnlyError reports whether err came from a database that is refusing writes,
+// e.g. a SQLite file mounted read-only or a Postgres/MySQL replica after a failover.
+// Drivers don't share an error type for this, so it matches on their messages.
+func isReadOnlyError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{
+		"readonly database",            // SQLite
+		"read-only transaction",        // Postgres (SQLSTATE 25006)
+		"--read-only option",           // MySQL (error 1290)
+		"--super-read-only option",     // MySQL
+		"database is in recovery mode", // Postgres standby
+	} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestIsReadOnlyError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("attempt to write a readonly database"), true},
+		{errors.New("ERROR: cannot execute INSERT in a read-only transaction (SQLSTATE 25006)"), true},
+		{errors.New("Error 1290: The MySQL server is running with the --read-only option so it cannot execute this statement"), true},
+		{fmt.Errorf("saving user: %w", errors.New("attempt to write a readonly database")), true},
+		{errors.New("UNIQUE constraint failed: users.username"), false},
+		{errors.New("database is locked"), false},
+	}
+	for _, tt := range tests {
+		if got := isReadOnlyError(tt.err); got != tt.want {
+			t.Errorf("isReadOnlyError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
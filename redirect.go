@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// isLocalPath reports whether target is a path on this site, the only place redirects taken
+// from user input may go. Browsers read "//host" and "/\host" as other hosts, and drop tabs
+// and newlines before doing so, so those are refused too.
+func isLocalPath(target string) bool {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.Contains(target, `\`) {
+		return false
+	}
+	for _, r := range target {
+		if r < 0x20 || r == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// loginPath is /login, remembering next to return to afterwards if it's a local path
+func loginPath(next string) string {
+	if next == "" || !isLocalPath(next) {
+		return "/login"
+	}
+	return "/login?next=" + url.QueryEscape(next)
+}
+
+// redirectToLogin sends a visitor who isn't logged in to /login, and back to the page they
+// asked for once they are. Only GETs are returned to; a form post can't be replayed.
+func redirectToLogin(c *fiber.Ctx, flash *FlashManager) error {
+	flash.Add(c, "Please log in first", "danger")
+	if c.Method() != fiber.MethodGet {
+		return redirect(c, "/login")
+	}
+	return redirect(c, loginPath(strings.TrimPrefix(c.OriginalURL(), basePath(c))))
+}
+
+// basePathLocal is the local BASE_PATH is kept in, which templates prefix their links with
+const basePathLocal = "base_path"
+
+// withBasePath makes BASE_PATH available to redirect and routePath, and to templates through
+// PassLocalsToViews. It runs first, so even early error pages get working links.
+func withBasePath(basePath string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Locals(basePathLocal, basePath)
+		return c.Next()
+	}
+}
+
+// basePath is the BASE_PATH the app is served under, "" at the root
+func basePath(c *fiber.Ctx) string {
+	path, _ := c.Locals(basePathLocal).(string)
+	return path
+}
+
+// cookiePath is the Path the app's cookies are set with: BASE_PATH, or the whole site at the
+// root. Without one, browsers scope a cookie to the directory of the URL that set it.
+func cookiePath(c *fiber.Ctx) string {
+	if path := basePath(c); path != "" {
+		return path
+	}
+	return "/"
+}
+
+// clearCookie removes the cookie name that was set with cookiePath. Fiber's ClearCookie sends
+// no Path, so it only removes a cookie scoped to the current directory.
+func clearCookie(c *fiber.Ctx, name string) {
+	c.Cookie(&fiber.Cookie{
+		Name:     name,
+		Path:     cookiePath(c),
+		Expires:  fasthttp.CookieExpireDelete,
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+}
+
+// redirect redirects to path, one of the app's own paths like "/login", under BASE_PATH
+func redirect(c *fiber.Ctx, path string, status ...int) error {
+	return c.Redirect(basePath(c)+path, status...)
+}
+
+// routePath is the request's path as the routes are written, without BASE_PATH
+func routePath(c *fiber.Ctx) string {
+	path := strings.TrimPrefix(c.Path(), basePath(c))
+	if path == "" {
+		return "/"
+	}
+	return path
+}
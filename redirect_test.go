@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestIsLocalPath(t *testing.T) {
+	tests := []struct {
+		target string
+		want   bool
+	}{
+		{"/", true},
+		{"/profile", true},
+		{"/admin?label=beta", true},
+		{"", false},
+		{"profile", false},
+		{"https://evil.example", false},
+		{"//evil.example", false},
+		{`/\evil.example`, false},
+		{"/\t/evil.example", false},
+		{"/\n/evil.example", false},
+	}
+	for _, tt := range tests {
+		if got := isLocalPath(tt.target); got != tt.want {
+			t.Errorf("isLocalPath(%q) = %v, want %v", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestLoginPath(t *testing.T) {
+	tests := []struct {
+		next string
+		want string
+	}{
+		{"", "/login"},
+		{"/profile", "/login?next=%2Fprofile"},
+		{"/admin?label=beta", "/login?next=%2Fadmin%3Flabel%3Dbeta"},
+		{"//evil.example", "/login"},
+	}
+	for _, tt := range tests {
+		if got := loginPath(tt.next); got != tt.want {
+			t.Errorf("loginPath(%q) = %q, want %q", tt.next, got, tt.want)
+		}
+	}
+}
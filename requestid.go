@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+)
+
+// maxRequestIDLength bounds the client-supplied X-Request-ID that is kept
+const maxRequestIDLength = 128
+
+// requestID gives every request an ID in the "requestid" local and the X-Request-ID response
+// header, for matching log lines and error reports. An ID the client sent is kept if it looks
+// like one, so requests can be traced across services; anything else is replaced, since the ID
+// ends up in logs and pages.
+func requestID() fiber.Handler {
+	assign := requestid.New()
+	return func(c *fiber.Ctx) error {
+		if id := c.Get(fiber.HeaderXRequestID); id != "" && !isValidRequestID(id) {
+			c.Request().Header.Del(fiber.HeaderXRequestID)
+		}
+		return assign(c)
+	}
+}
+
+// isValidRequestID allows IDs like UUIDs and trace IDs: letters, digits, '-', '_' and '.'
+func isValidRequestID(id string) bool {
+	if len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// currentRequestID returns the ID requestID assigned, or "" before it has run
+func currentRequestID(c *fiber.Ctx) string {
+	id, _ := c.Locals("requestid").(string)
+	return id
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIsValidRequestID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{"8911ebd5-3ddf-4d7c-99d6-7f7df7a65ae0", true},
+		{"trace.0af7651916cd43dd_b7ad6b7169203331", true},
+		{"bad id", false},
+		{"<script>", false},
+		{strings.Repeat("a", maxRequestIDLength), true},
+		{strings.Repeat("a", maxRequestIDLength+1), false},
+	}
+	for _, tt := range tests {
+		if got := isValidRequestID(tt.id); got != tt.want {
+			t.Errorf("isValidRequestID(%q) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
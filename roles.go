@@ -0,0 +1,78 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Roles a user can have, stored in User.Role
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// Permissions are what the frontend checks to show or hide UI; roles grant them through rolePermissions
+const (
+	PermissionEditPreferences = "preferences:edit"
+	PermissionListUsers       = "users:list"
+	PermissionViewStats       = "stats:view"
+	PermissionViewDebug       = "debug:view"
+)
+
+// rolePermissions is the single place roles are mapped to permissions
+var rolePermissions = map[string][]string{
+	RoleUser:  {PermissionEditPreferences},
+	RoleAdmin: {PermissionEditPreferences, PermissionListUsers, PermissionViewStats, PermissionViewDebug},
+}
+
+// permissionsFor returns the permissions granted to role, none for an unknown role
+func permissionsFor(role string) []string {
+	permissions := rolePermissions[role]
+	if permissions == nil {
+		return []string{}
+	}
+	return permissions
+}
+
+// requireRole rejects requests unless the logged-in user has the given role: 401 when nobody
+// is logged in, 403 when the user lacks the role. API clients get a JSON error, browsers the
+// app's error page. It relies on loadUser having run first.
+func requireRole(role string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user := currentUser(c)
+		if user == nil {
+			if wantsHTML(c) {
+				return fiber.ErrUnauthorized
+			}
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+		}
+		if user.Role != role {
+			if wantsHTML(c) {
+				return fiber.ErrForbidden
+			}
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "forbidden"})
+		}
+		return c.Next()
+	}
+}
+
+// requireAdmin rejects requests from anyone but admins, see requireRole
+func requireAdmin() fiber.Handler {
+	return requireRole(RoleAdmin)
+}
+
+// bootstrapAdmin gives the admin role to username, so a fresh install can get its first admin
+// from ADMIN_USERNAME. It reports whether such a user exists yet. Only startup calls it, for an
+// account the operator already registered; registering the name later doesn't make anyone admin.
+func bootstrapAdmin(db *gorm.DB, username string) (bool, error) {
+	result := db.Model(&User{}).Where("username = ? AND role <> ?", username, RoleAdmin).Update("role", RoleAdmin)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return true, nil
+	}
+	var count int64
+	err := db.Model(&User{}).Where("username = ?", username).Count(&count).Error
+	return count > 0, err
+}
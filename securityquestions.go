@@ -0,0 +1,234 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// SecurityAnswer is a user's hashed answer to one of securityQuestions
+type SecurityAnswer struct {
+	gorm.Model
+	UserID     uint `gorm:"index"`
+	Question   string
+	AnswerHash string
+}
+
+// securityQuestions are the questions users can pick from, requiredSecurityAnswers of them
+var securityQuestions = []string{
+	"What was the name of your first pet?",
+	"In what city were you born?",
+	"What was the name of your first school?",
+	"What is your favourite book?",
+	"What was the make of your first car?",
+}
+
+const (
+	requiredSecurityAnswers = 2
+
+	// Recovery attempts allowed per IP and username, so answers can't be guessed quickly, and
+	// per IP across usernames, so one client can't work through many accounts instead
+	recoveryAttemptsMax      = 5
+	recoveryAttemptsPerIPMax = 20
+	recoveryAttemptsWindow   = 15 * time.Minute
+)
+
+// normalizeAnswer makes answers match regardless of case and spacing. bcrypt ignores anything
+// past 72 bytes, so longer answers are cut there explicitly.
+func normalizeAnswer(answer string) string {
+	answer = strings.Join(strings.Fields(strings.ToLower(answer)), " ")
+	if len(answer) > 72 {
+		answer = answer[:72]
+	}
+	return answer
+}
+
+// setupSecurityQuestions registers /profile/security for choosing questions and the /recover
+// flow, which resets a password once every answer matches
+func (h *Handlers) setupSecurityQuestions(app fiber.Router, loggedIn fiber.Handler) {
+	app.Get("/profile/security", loggedIn, h.showSecurityQuestions)
+	app.Post("/profile/security", loggedIn, h.saveSecurityQuestions)
+	app.Get("/recover", h.showRecover)
+	app.Post("/recover", h.recoveryQuestions)
+
+	tooManyAttempts := func(c *fiber.Ctx) error {
+		h.flash.Add(c, "Too many recovery attempts, please try again later", "danger")
+		return redirect(c, "/recover")
+	}
+	app.Post("/recover/reset", limiter.New(limiter.Config{
+		Max:          recoveryAttemptsPerIPMax,
+		Expiration:   recoveryAttemptsWindow,
+		LimitReached: tooManyAttempts,
+	}), limiter.New(limiter.Config{
+		Max:        recoveryAttemptsMax,
+		Expiration: recoveryAttemptsWindow,
+		KeyGenerator: func(c *fiber.Ctx) string {
+			return c.IP() + "|" + normalizeUsername(c.FormValue("username"))
+		},
+		LimitReached: tooManyAttempts,
+	}), h.resetWithAnswers)
+}
+
+// showSecurityQuestions answers GET /profile/security
+func (h *Handlers) showSecurityQuestions(c *fiber.Ctx) error {
+	user := currentUser(c)
+	var count int64
+	h.db.WithContext(c.UserContext()).Model(&SecurityAnswer{}).Where("user_id = ?", user.ID).Count(&count)
+	return c.Render("security", prepareTemplateData(c, fiber.Map{
+		"Questions":  securityQuestions,
+		"Slots":      make([]struct{}, requiredSecurityAnswers),
+		"Configured": count > 0,
+	}, h.flash))
+}
+
+// saveSecurityQuestions stores the questions and answers picked on /profile/security
+func (h *Handlers) saveSecurityQuestions(c *fiber.Ctx) error {
+	user := currentUser(c)
+
+	if h.cfg.ReadOnly {
+		h.flash.Add(c, readOnlyMessage, "warning")
+		return redirect(c, "/profile/security")
+	}
+
+	questions := formValues(c, "question")
+	answers := formValues(c, "answer")
+	if result := validateSecurityAnswers(questions, answers); !result.Valid() {
+		result.ToFlashes(c, h.flash)
+		return redirect(c, "/profile/security")
+	}
+
+	rows := make([]SecurityAnswer, len(questions))
+	for i, question := range questions {
+		hash, err := bcrypt.GenerateFromPassword([]byte(normalizeAnswer(answers[i])), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		rows[i] = SecurityAnswer{UserID: user.ID, Question: question, AnswerHash: string(hash)}
+	}
+
+	// Replace any earlier answers, so only the latest set can be used for recovery
+	err := h.db.WithContext(c.UserContext()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&SecurityAnswer{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&rows).Error
+	})
+	if err != nil {
+		if isReadOnlyError(err) {
+			h.flash.Add(c, readOnlyMessage, "warning")
+			return redirect(c, "/profile/security")
+		}
+		return err
+	}
+
+	h.flash.Add(c, "Security questions saved", "success")
+	return redirect(c, "/profile/security")
+}
+
+// showRecover answers GET /recover, which asks for the account to recover
+func (h *Handlers) showRecover(c *fiber.Ctx) error {
+	if currentUser(c) != nil {
+		h.flash.Add(c, "Already logged in", "danger")
+		return redirect(c, "/")
+	}
+	return c.Render("recover", prepareTemplateData(c, nil, h.flash))
+}
+
+// recoveryQuestions is step one of recovery: it shows the questions the account picked
+func (h *Handlers) recoveryQuestions(c *fiber.Ctx) error {
+	username := normalizeUsername(c.FormValue("username"))
+	var user User
+	var answers []SecurityAnswer
+	if err := h.db.WithContext(c.UserContext()).Where("username = ?", username).Limit(1).Find(&user).Error; err != nil {
+		return err
+	}
+	if user.ID != 0 {
+		if err := h.db.WithContext(c.UserContext()).Where("user_id = ?", user.ID).Order("id").Find(&answers).Error; err != nil {
+			return err
+		}
+	}
+	// Unknown users get the same message, so this doesn't confirm which accounts exist
+	if len(answers) == 0 {
+		h.flash.Add(c, "Account recovery isn't set up for that account", "danger")
+		return redirect(c, "/recover")
+	}
+	return c.Render("recover_answers", prepareTemplateData(c, fiber.Map{
+		"Username": user.Username,
+		"Answers":  answers,
+	}, h.flash))
+}
+
+// resetWithAnswers is step two of recovery: it checks the answers and sets the new password
+func (h *Handlers) resetWithAnswers(c *fiber.Ctx) error {
+	if h.cfg.ReadOnly {
+		h.flash.Add(c, readOnlyMessage, "warning")
+		return redirect(c, "/recover")
+	}
+
+	username := normalizeUsername(c.FormValue("username"))
+	password := c.FormValue("password")
+	if result := validateCredentials(username, password); !result.Valid() {
+		result.ToFlashes(c, h.flash)
+		return redirect(c, "/recover")
+	}
+
+	var user User
+	var answers []SecurityAnswer
+	if err := h.db.WithContext(c.UserContext()).Where("username = ?", username).Limit(1).Find(&user).Error; err != nil {
+		return err
+	}
+	if user.ID != 0 {
+		if err := h.db.WithContext(c.UserContext()).Where("user_id = ?", user.ID).Find(&answers).Error; err != nil {
+			return err
+		}
+	}
+
+	// Every answer is checked even after a mismatch, so the timing doesn't tell which one was wrong
+	matched := len(answers) > 0
+	for _, answer := range answers {
+		given := normalizeAnswer(c.FormValue("answer_" + strconv.FormatUint(uint64(answer.ID), 10)))
+		if bcrypt.CompareHashAndPassword([]byte(answer.AnswerHash), []byte(given)) != nil {
+			matched = false
+		}
+	}
+	if !matched {
+		h.flash.Add(c, "Those answers didn't match", "danger")
+		return redirect(c, "/recover")
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	// Whoever knew the old password is logged out everywhere, and the lockout from their
+	// guesses no longer applies to the owner
+	err = h.db.WithContext(c.UserContext()).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&user).Updates(map[string]any{
+			"password":      string(hashedPassword),
+			"failed_logins": 0,
+			"locked_until":  nil,
+		}).Error
+		if err != nil {
+			return err
+		}
+		if err := forgetUserSessions(c.UserContext(), tx, user.ID); err != nil {
+			return err
+		}
+		return revokeAPITokens(c.UserContext(), tx, user.ID)
+	})
+	if err != nil {
+		if isReadOnlyError(err) {
+			h.flash.Add(c, readOnlyMessage, "warning")
+			return redirect(c, "/recover")
+		}
+		return err
+	}
+
+	h.flash.Add(c, "Password reset, you can now log in", "success")
+	return redirect(c, "/login")
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// addTestSecurityAnswers gives user an answer of "answer" to each of the first questions
+func addTestSecurityAnswers(t *testing.T, h *Handlers, user User) []SecurityAnswer {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("answer"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answers := make([]SecurityAnswer, requiredSecurityAnswers)
+	for i := range answers {
+		answers[i] = SecurityAnswer{UserID: user.ID, Question: securityQuestions[i], AnswerHash: string(hash)}
+	}
+	if err := h.db.Create(&answers).Error; err != nil {
+		t.Fatal(err)
+	}
+	return answers
+}
+
+// resetForm is a /recover/reset submission for username answering every answer with answer
+func resetForm(username, answer string, answers []SecurityAnswer) url.Values {
+	form := url.Values{"username": {username}, "password": {"newsecret456"}}
+	for _, a := range answers {
+		form.Set("answer_"+strconv.FormatUint(uint64(a.ID), 10), answer)
+	}
+	return form
+}
+
+func TestRecoverResetEndsSessionsAndLockout(t *testing.T) {
+	h := newTestHandlers(t, Config{})
+	alice := createTestUser(t, h.db, "alice", "secret123")
+	answers := addTestSecurityAnswers(t, h, alice)
+	lockedUntil := time.Now().Add(time.Hour)
+	if err := h.db.Model(&alice).Updates(map[string]any{"failed_logins": lockoutThreshold, "locked_until": lockedUntil}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := recordSession(context.Background(), h.db, alice.ID, "stolen-session", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	app := newTestApp(nil)
+	h.setupSecurityQuestions(app, requireLogin(h.flash))
+
+	doRequest(t, app, postForm("/recover/reset", resetForm("alice", "answer", answers)))
+
+	if _, err := authenticate(context.Background(), h.db, "alice", "newsecret456"); err != nil {
+		t.Fatalf("logging in with the new password: %v", err)
+	}
+	if recorded, _ := sessionRecorded(context.Background(), h.db, alice.ID, "stolen-session"); recorded {
+		t.Error("the reset left the account's other sessions logged in")
+	}
+}
+
+func TestRecoverResetIsLimitedPerIP(t *testing.T) {
+	h := newTestHandlers(t, Config{})
+	alice := createTestUser(t, h.db, "alice", "secret123")
+	answers := addTestSecurityAnswers(t, h, alice)
+	app := newTestApp(nil)
+	h.setupSecurityQuestions(app, requireLogin(h.flash))
+
+	// Each username stays under its own limit, but together they use up the IP's
+	for i := 0; i < recoveryAttemptsPerIPMax; i++ {
+		doRequest(t, app, postForm("/recover/reset", resetForm("user"+strconv.Itoa(i), "guess", nil)))
+	}
+	doRequest(t, app, postForm("/recover/reset", resetForm("alice", "answer", answers)))
+
+	if _, err := authenticate(context.Background(), h.db, "alice", "secret123"); err != nil {
+		t.Errorf("the password was reset after the IP's attempts ran out: %v", err)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Session lifecycle events, logged by logSessionEvent
+const (
+	sessionCreated   = "created"
+	sessionDestroyed = "destroyed"
+)
+
+// logSessionEvent emits a structured log line when a logged-in session starts or ends, for
+// security monitoring. The session ID is logged hashed, since the raw ID is a credential.
+func logSessionEvent(c *fiber.Ctx, cfg Config, event, reason, sessionID string, userID any) {
+	if !cfg.SessionEventLog {
+		return
+	}
+	slog.Info("session "+event,
+		"event", "session_"+event,
+		"reason", reason,
+		"user_id", userID,
+		"session_id_hash", hashSessionID(sessionID),
+		"ip", c.IP(),
+	)
+}
+
+// hashSessionID returns the hex SHA-256 of a session ID, safe to log or store. Session IDs are
+// 32 random bytes from secureToken, so an unsalted fast hash is enough to make them irreversible.
+func hashSessionID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sessionValidator asks an external service, such as an SSO provider, whether a session is
+// still valid, so sessions revoked centrally stop working here too. It sends
+//
+//	POST <SESSION_VALIDATION_URL>
+//	Content-Type: application/x-www-form-urlencoded
+//
+//	session_id=<id>&user_id=<id>
+//
+// and treats 2xx as valid, 401/403/404 as revoked and anything else as the service being
+// unavailable. The session ID goes in the body rather than the URL, which proxies and the
+// service's access logs would record. Answers are cached for a while so the service isn't
+// called on every request.
+type sessionValidator struct {
+	url      string
+	client   *http.Client
+	ttl      time.Duration
+	failOpen bool
+
+	mu    sync.Mutex
+	cache map[string]cachedValidation
+}
+
+type cachedValidation struct {
+	valid   bool
+	expires time.Time
+}
+
+// maxCachedValidations bounds the cache. Once it's reached expired entries are swept, and if
+// none have expired the oldest goes instead.
+const maxCachedValidations = 10000
+
+// newSessionValidator returns nil when no validation service is configured
+func newSessionValidator(cfg Config) *sessionValidator {
+	if cfg.SessionValidationURL == "" {
+		return nil
+	}
+	return &sessionValidator{
+		url:      cfg.SessionValidationURL,
+		client:   &http.Client{Timeout: cfg.SessionValidationTimeout},
+		ttl:      cfg.SessionValidationCacheTTL,
+		failOpen: cfg.SessionValidationFailOpen,
+		cache:    make(map[string]cachedValidation),
+	}
+}
+
+// valid reports whether the session may still be used. When the service can't be reached
+// the answer depends on failOpen, and isn't cached so the next request tries again.
+func (v *sessionValidator) valid(ctx context.Context, sessionID string, userID uint) bool {
+	v.mu.Lock()
+	cached, ok := v.cache[sessionID]
+	v.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.valid
+	}
+
+	valid, err := v.check(ctx, sessionID, userID)
+	if err != nil {
+		slog.Error("session validation failed", "error", err)
+		return v.failOpen
+	}
+
+	v.mu.Lock()
+	if len(v.cache) >= maxCachedValidations {
+		now := time.Now()
+		oldest := ""
+		for id, c := range v.cache {
+			if now.After(c.expires) {
+				delete(v.cache, id)
+			} else if oldest == "" || c.expires.Before(v.cache[oldest].expires) {
+				oldest = id
+			}
+		}
+		// Every entry has the same TTL, so the one expiring first was cached first
+		if len(v.cache) >= maxCachedValidations {
+			delete(v.cache, oldest)
+		}
+	}
+	v.cache[sessionID] = cachedValidation{valid: valid, expires: time.Now().Add(v.ttl)}
+	v.mu.Unlock()
+	return valid
+}
+
+func (v *sessionValidator) check(ctx context.Context, sessionID string, userID uint) (bool, error) {
+	form := url.Values{
+		"session_id": {sessionID},
+		"user_id":    {strconv.FormatUint(uint64(userID), 10)},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return true, nil
+	case resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusForbidden, resp.StatusCode == http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected status %d from session validation service", resp.StatusCode)
+	}
+}
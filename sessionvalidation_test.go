@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSessionValidator(t *testing.T) {
+	var mu sync.Mutex
+	var status int
+	var lastRequest *http.Request
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		mu.Lock()
+		defer mu.Unlock()
+		lastRequest = r
+		w.WriteHeader(status)
+	}))
+	defer service.Close()
+
+	tests := []struct {
+		name     string
+		status   int
+		failOpen bool
+		want     bool
+	}{
+		{"valid", http.StatusNoContent, false, true},
+		{"revoked", http.StatusUnauthorized, false, false},
+		{"unknown", http.StatusNotFound, true, false},
+		{"service down, fail closed", http.StatusBadGateway, false, false},
+		{"service down, fail open", http.StatusBadGateway, true, true},
+	}
+	for _, tt := range tests {
+		mu.Lock()
+		status = tt.status
+		mu.Unlock()
+		v := newSessionValidator(Config{
+			SessionValidationURL:      service.URL + "/validate",
+			SessionValidationTimeout:  time.Second,
+			SessionValidationCacheTTL: time.Minute,
+			SessionValidationFailOpen: tt.failOpen,
+		})
+		if got := v.valid(context.Background(), "session-1", 7); got != tt.want {
+			t.Errorf("%s: valid = %v, want %v", tt.name, got, tt.want)
+		}
+
+		// The IDs go in the body, so they don't end up in URLs and access logs
+		mu.Lock()
+		if lastRequest.Method != http.MethodPost || lastRequest.URL.RawQuery != "" {
+			t.Errorf("%s: sent %s %s, want a POST without a query", tt.name, lastRequest.Method, lastRequest.URL)
+		}
+		if lastRequest.PostForm.Get("session_id") != "session-1" || lastRequest.PostForm.Get("user_id") != "7" {
+			t.Errorf("%s: sent form %v, want the session and user IDs", tt.name, lastRequest.PostForm)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestSessionValidatorCachesAnswers(t *testing.T) {
+	var calls atomic.Int32
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer service.Close()
+	v := newSessionValidator(Config{
+		SessionValidationURL:      service.URL,
+		SessionValidationTimeout:  time.Second,
+		SessionValidationCacheTTL: time.Minute,
+	})
+
+	for i := 0; i < 3; i++ {
+		v.valid(context.Background(), "session-1", 7)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("the service was asked %d times, want once while the answer is cached", n)
+	}
+}
+
+func TestSessionValidatorCacheStaysBounded(t *testing.T) {
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer service.Close()
+	v := newSessionValidator(Config{
+		SessionValidationURL:      service.URL,
+		SessionValidationTimeout:  time.Second,
+		SessionValidationCacheTTL: time.Minute,
+	})
+
+	// A full cache with nothing expired yet
+	now := time.Now()
+	for i := 0; i < maxCachedValidations; i++ {
+		v.cache["session-"+strconv.Itoa(i)] = cachedValidation{valid: true, expires: now.Add(time.Minute + time.Duration(i)*time.Millisecond)}
+	}
+	v.valid(context.Background(), "new-session", 7)
+
+	if len(v.cache) > maxCachedValidations {
+		t.Errorf("%d cached answers, want at most %d", len(v.cache), maxCachedValidations)
+	}
+	if _, ok := v.cache["session-0"]; ok {
+		t.Error("the oldest answer is still cached")
+	}
+	if _, ok := v.cache["new-session"]; !ok {
+		t.Error("the new answer wasn't cached")
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// subsystem is a part of the app that has to be stopped cleanly before the process exits
+type subsystem struct {
+	name    string
+	timeout time.Duration
+	stop    func(ctx context.Context) error
+}
+
+// shutdown stops the subsystems one after another, in the order given, so that e.g. the
+// HTTP server stops accepting work before the things it depends on are closed. Each
+// subsystem gets its own timeout; one that overruns is logged and abandoned so the
+// rest still get their turn.
+func shutdown(subsystems []subsystem) {
+	for _, s := range subsystems {
+		start := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+		err := stopWithContext(ctx, s.stop)
+		cancel()
+
+		if err != nil {
+			slog.Error("shutdown failed", "subsystem", s.name, "elapsed", time.Since(start).Round(time.Millisecond), "error", err)
+			continue
+		}
+		slog.Info("shutdown done", "subsystem", s.name, "elapsed", time.Since(start).Round(time.Millisecond))
+	}
+}
+
+// stopWithContext runs stop but returns as soon as ctx is done, for stop functions that ignore ctx
+func stopWithContext(ctx context.Context, stop func(ctx context.Context) error) error {
+	done := make(chan error, 1)
+	go func() { done <- stop(ctx) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// queryCountKey is the context key for the per-request query counter
+type queryCountKey struct{}
+
+// slowRequestLogger logs every request slower than threshold at warning level, independently
+// of the access log. Queries are counted for handlers that use db.WithContext(c.UserContext()).
+func slowRequestLogger(threshold time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		queries := new(atomic.Int64)
+		c.SetUserContext(context.WithValue(c.UserContext(), queryCountKey{}, queries))
+
+		start := time.Now()
+		err := c.Next()
+		elapsed := time.Since(start)
+		if elapsed < threshold {
+			return err
+		}
+
+		attrs := []any{
+			"method", c.Method(),
+			"path", c.Path(),
+			"status", c.Response().StatusCode(),
+			"duration_ms", elapsed.Milliseconds(),
+			"queries", queries.Load(),
+			"request_id", currentRequestID(c),
+		}
+		if user := currentUser(c); user != nil {
+			attrs = append(attrs, "user_id", user.ID)
+		}
+		slog.Warn("slow request", attrs...)
+		return err
+	}
+}
+
+// registerQueryCounter adds GORM callbacks that count statements against the request
+// counter found in the statement's context, if any
+func registerQueryCounter(db *gorm.DB) error {
+	count := func(tx *gorm.DB) {
+		if n, ok := tx.Statement.Context.Value(queryCountKey{}).(*atomic.Int64); ok {
+			n.Add(1)
+		}
+	}
+
+	cb := db.Callback()
+	for _, err := range []error{
+		cb.Query().After("gorm:query").Register("app:count_queries", count),
+		cb.Create().After("gorm:create").Register("app:count_queries", count),
+		cb.Update().After("gorm:update").Register("app:count_queries", count),
+		cb.Delete().After("gorm:delete").Register("app:count_queries", count),
+		cb.Row().After("gorm:row").Register("app:count_queries", count),
+		cb.Raw().After("gorm:raw").Register("app:count_queries", count),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
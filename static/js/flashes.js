@@ -0,0 +1,10 @@
+// Shows flashes delivered in an HX-Trigger header to HTMX partial requests, see htmx.go
+document.body.addEventListener("flash", function (event) {
+    var container = document.getElementById("flash-messages");
+    (event.detail.messages || []).forEach(function (flash) {
+        var alert = document.createElement("div");
+        alert.className = "alert alert-" + flash.category;
+        alert.textContent = flash.message;
+        container.appendChild(alert);
+    });
+});
@@ -0,0 +1,27 @@
+// Check whether the chosen username is free while the user fills in the form
+(function () {
+    // The app may be served under a sub-path, which the page passes in
+    const basePath = document.currentScript.dataset.basePath || "";
+    const input = document.querySelector('input[name="username"]');
+    const feedback = document.getElementById("username-feedback");
+    if (!input || !feedback) {
+        return;
+    }
+
+    input.addEventListener("change", async function () {
+        const username = input.value.trim();
+        feedback.textContent = "";
+        input.classList.remove("is-valid", "is-invalid");
+        if (username.length < 5) {
+            return;
+        }
+
+        const res = await fetch(basePath + "/api/v1/username-available?username=" + encodeURIComponent(username));
+        if (!res.ok) {
+            return;
+        }
+        const body = await res.json();
+        input.classList.add(body.available ? "is-valid" : "is-invalid");
+        feedback.textContent = body.available ? "" : "That username is not available";
+    });
+})();
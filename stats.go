@@ -0,0 +1,51 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxStatsDays bounds ?days= on the stats endpoints
+const maxStatsDays = 365
+
+// dailyCount is one point of a daily time series
+type dailyCount struct {
+	Date  string `json:"date"` // YYYY-MM-DD, UTC
+	Count int64  `json:"count"`
+}
+
+// registrationStats returns the number of users registered per day over the last ?days=
+// days (default 30), with days without registrations included as zero. Deleted accounts still
+// count, so a deletion doesn't rewrite the history.
+func (h *Handlers) registrationStats(c *fiber.Ctx) error {
+	days := c.QueryInt("days", 30)
+	if days < 1 || days > maxStatsDays {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "days must be between 1 and 365"})
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	since := today.AddDate(0, 0, -(days - 1))
+
+	// Bucketed here rather than in SQL, where each database has its own idea of the time zone
+	// a timestamp is in (MySQL's is the session's, not UTC)
+	var registered []time.Time
+	err := h.db.WithContext(c.UserContext()).Unscoped().Model(&User{}).
+		Where("created_at >= ?", since).
+		Pluck("created_at", &registered).Error
+	if err != nil {
+		return err
+	}
+
+	counts := make(map[string]int64, days)
+	for _, at := range registered {
+		counts[at.UTC().Format(time.DateOnly)]++
+	}
+	series := make([]dailyCount, 0, days)
+	for d := since; !d.After(today); d = d.AddDate(0, 0, 1) {
+		date := d.Format(time.DateOnly)
+		series = append(series, dailyCount{Date: date, Count: counts[date]})
+	}
+
+	return c.JSON(fiber.Map{"days": days, "data": series})
+}
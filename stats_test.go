@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestRegistrationStats(t *testing.T) {
+	h := newTestHandlers(t, Config{})
+	app := newTestApp(nil)
+	app.Get("/stats/registrations", h.registrationStats)
+
+	today := time.Now().UTC()
+	// Days ago each user registered; the last is outside the three days asked for
+	registered := map[string]int{"alice": 0, "bobby": 0, "carol": 2, "david": 40}
+	for username, daysAgo := range registered {
+		user := createTestUser(t, h.db, username, "secret123")
+		if err := h.db.Model(&user).Update("created_at", today.AddDate(0, 0, -daysAgo)).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Stored in another time zone, but still today in UTC
+	early := time.Date(today.Year(), today.Month(), today.Day(), 0, 30, 0, 0, time.UTC).In(time.FixedZone("UTC-5", -5*60*60))
+	erin := createTestUser(t, h.db, "erin1", "secret123")
+	if err := h.db.Model(&erin).Update("created_at", early).Error; err != nil {
+		t.Fatal(err)
+	}
+	// A deleted account still registered when it did
+	if err := h.db.Delete(&erin).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	resp := doRequest(t, app, httptest.NewRequest(fiber.MethodGet, "/stats/registrations?days=3", nil))
+	var body struct {
+		Days int          `json:"days"`
+		Data []dailyCount `json:"data"`
+	}
+	decodeJSON(t, resp, &body)
+	want := []dailyCount{
+		{today.AddDate(0, 0, -2).Format(time.DateOnly), 1},
+		{today.AddDate(0, 0, -1).Format(time.DateOnly), 0},
+		{today.Format(time.DateOnly), 3},
+	}
+	if body.Days != 3 || len(body.Data) != len(want) {
+		t.Fatalf("got %+v, want 3 days of data", body)
+	}
+	for i := range want {
+		if body.Data[i] != want[i] {
+			t.Errorf("day %d = %+v, want %+v", i, body.Data[i], want[i])
+		}
+	}
+
+	for _, days := range []string{"0", "366"} {
+		resp := doRequest(t, app, httptest.NewRequest(fiber.MethodGet, "/stats/registrations?days="+days, nil))
+		if resp.StatusCode != fiber.StatusBadRequest {
+			t.Errorf("days=%s answered %d, want 400", days, resp.StatusCode)
+		}
+	}
+}
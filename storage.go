@@ -0,0 +1,105 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SessionData is a session saved by sqlStorage
+type SessionData struct {
+	ID        string `gorm:"primaryKey"` // the session ID, or its SHA-256 when hashing is on
+	Value     []byte
+	ExpiresAt int64 `gorm:"index"` // unix seconds, 0 means it never expires
+}
+
+// sqlStorage implements fiber.Storage on top of the application database,
+// so sessions survive restarts without running anything besides the app
+type sqlStorage struct {
+	db         *gorm.DB
+	gcInterval time.Duration
+	done       chan struct{}
+	// hashIDs stores and looks up sessions by the hash of their ID, so a leaked database
+	// doesn't hand out live session tokens
+	hashIDs bool
+}
+
+func newSQLStorage(db *gorm.DB, gcInterval time.Duration, hashIDs bool) (*sqlStorage, error) {
+	if err := db.AutoMigrate(&SessionData{}); err != nil {
+		return nil, err
+	}
+	s := &sqlStorage{db: db, gcInterval: gcInterval, done: make(chan struct{}), hashIDs: hashIDs}
+	go s.gc()
+	return s, nil
+}
+
+// Get returns nil, nil for missing or expired keys, as fiber.Storage expects
+func (s *sqlStorage) Get(key string) ([]byte, error) {
+	if key == "" {
+		return nil, nil
+	}
+	// Find rather than First, since a missing session is routine and shouldn't be logged as an error
+	var rows []SessionData
+	err := s.db.Where("id = ? AND (expires_at = 0 OR expires_at > ?)", s.rowID(key), time.Now().Unix()).Limit(1).Find(&rows).Error
+	if err != nil || len(rows) == 0 {
+		return nil, err
+	}
+	return rows[0].Value, nil
+}
+
+func (s *sqlStorage) Set(key string, val []byte, exp time.Duration) error {
+	if key == "" || len(val) == 0 {
+		return nil
+	}
+	row := SessionData{ID: s.rowID(key), Value: val}
+	if exp != 0 {
+		row.ExpiresAt = time.Now().Add(exp).Unix()
+	}
+	return s.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&row).Error
+}
+
+func (s *sqlStorage) Delete(key string) error {
+	if key == "" {
+		return nil
+	}
+	return s.db.Where("id = ?", s.rowID(key)).Delete(&SessionData{}).Error
+}
+
+// rowID is the primary key a session ID is stored under
+func (s *sqlStorage) rowID(key string) string {
+	if s.hashIDs {
+		return hashSessionID(key)
+	}
+	return key
+}
+
+func (s *sqlStorage) Reset() error {
+	return s.db.Where("1 = 1").Delete(&SessionData{}).Error
+}
+
+// Close stops the cleanup job; the database itself belongs to the app
+func (s *sqlStorage) Close() error {
+	close(s.done)
+	return nil
+}
+
+// gc periodically removes expired sessions so the table doesn't grow forever
+func (s *sqlStorage) gc() {
+	ticker := time.NewTicker(s.gcInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			result := s.db.Where("expires_at <> 0 AND expires_at <= ?", time.Now().Unix()).Delete(&SessionData{})
+			if result.Error != nil {
+				slog.Error("error cleaning up sessions", "error", result.Error)
+			} else if result.RowsAffected > 0 {
+				slog.Info("expired sessions removed", "event", "session_destroyed", "reason", "expired", "count", result.RowsAffected)
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// newTestSQLStorage returns a sqlStorage on a test database, closed when the test ends
+func newTestSQLStorage(t *testing.T, hashIDs bool) *sqlStorage {
+	t.Helper()
+	storage, err := newSQLStorage(newTestDB(t), time.Hour, hashIDs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { storage.Close() })
+	return storage
+}
+
+func TestSQLStorage(t *testing.T) {
+	storage := newTestSQLStorage(t, false)
+
+	tests := []struct {
+		name  string
+		key   string
+		value []byte
+		exp   time.Duration
+		want  []byte
+	}{
+		{"saved", "session-1", []byte("first"), time.Hour, []byte("first")},
+		{"saved again", "session-1", []byte("second"), time.Hour, []byte("second")},
+		{"without expiry", "session-2", []byte("forever"), 0, []byte("forever")},
+		{"expired", "session-3", []byte("stale"), -time.Minute, nil},
+	}
+	for _, tt := range tests {
+		if err := storage.Set(tt.key, tt.value, tt.exp); err != nil {
+			t.Fatalf("%s: Set: %v", tt.name, err)
+		}
+		got, err := storage.Get(tt.key)
+		if err != nil {
+			t.Fatalf("%s: Get: %v", tt.name, err)
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: Get = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	if err := storage.Delete("session-1"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := storage.Get("session-1"); err != nil || got != nil {
+		t.Errorf("Get after Delete = %q, %v, want nothing", got, err)
+	}
+	if got, err := storage.Get("unknown"); err != nil || got != nil {
+		t.Errorf("Get of an unknown session = %q, %v, want nil, nil", got, err)
+	}
+}
+
+func TestSQLStorageHashesIDs(t *testing.T) {
+	storage := newTestSQLStorage(t, true)
+	if err := storage.Set("session-1", []byte("data"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := storage.Get("session-1"); err != nil || string(got) != "data" {
+		t.Fatalf("Get = %q, %v, want the saved data", got, err)
+	}
+
+	var ids []string
+	if err := storage.db.Model(&SessionData{}).Pluck("id", &ids).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0] != hashSessionID("session-1") {
+		t.Errorf("stored IDs %q, want only the hash of the session ID", ids)
+	}
+	// Someone reading the table can't use the stored value as a session ID
+	if got, _ := storage.Get(ids[0]); got != nil {
+		t.Error("the stored hash works as a session ID")
+	}
+}
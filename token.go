@@ -0,0 +1,25 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+)
+
+// minTokenBytes is the least randomness any token gets, whatever its caller asks for.
+// 16 bytes (128 bits) can't be guessed or enumerated online.
+const minTokenBytes = 16
+
+// secureToken returns nBytes (at least minTokenBytes) from crypto/rand as URL-safe base64
+// without padding, so the token can go in URLs, cookies and form fields as is. Every
+// unguessable value the app hands out, such as session IDs and nonces, comes from here.
+func secureToken(nBytes int) string {
+	if nBytes < minTokenBytes {
+		nBytes = minTokenBytes
+	}
+	b := make([]byte, nBytes)
+	if _, err := rand.Read(b); err != nil {
+		// The system's randomness source is broken; no token is safe to hand out
+		panic("secureToken: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
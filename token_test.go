@@ -0,0 +1,47 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+var urlSafeToken = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func TestSecureTokenLength(t *testing.T) {
+	tests := []struct {
+		nBytes int
+		want   int
+	}{
+		{16, 22},
+		{32, 43},
+		{64, 86},
+		// Requests below the minimum get minTokenBytes
+		{0, 22},
+		{8, 22},
+	}
+	for _, tt := range tests {
+		if got := len(secureToken(tt.nBytes)); got != tt.want {
+			t.Errorf("len(secureToken(%d)) = %d, want %d", tt.nBytes, got, tt.want)
+		}
+	}
+}
+
+func TestSecureTokenCharacterSet(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		if token := secureToken(32); !urlSafeToken.MatchString(token) {
+			t.Fatalf("secureToken(32) = %q, want only URL-safe base64 characters", token)
+		}
+	}
+}
+
+func TestSecureTokenUnique(t *testing.T) {
+	const n = 10000
+	seen := make(map[string]bool, n)
+	for i := 0; i < n; i++ {
+		token := secureToken(minTokenBytes)
+		if seen[token] {
+			t.Fatalf("secureToken returned %q twice in %d calls", token, i+1)
+		}
+		seen[token] = true
+	}
+}
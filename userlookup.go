@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+	"gorm.io/gorm"
+)
+
+// userLoader fetches the logged-in user for loadUser. With dedupe on, concurrent lookups of the
+// same ID, e.g. a page and its API calls arriving together, share a single query.
+type userLoader struct {
+	db    *gorm.DB
+	group *singleflight.Group
+}
+
+func newUserLoader(db *gorm.DB, dedupe bool) *userLoader {
+	loader := &userLoader{db: db}
+	if dedupe {
+		loader.group = &singleflight.Group{}
+	}
+	return loader
+}
+
+// sharedLookupTimeout bounds a shared lookup, which no longer ends with the request that started it
+const sharedLookupTimeout = 5 * time.Second
+
+// load returns the user with the given ID. A shared query keeps the values of the request that
+// started it, so it's counted against that request only, but not its cancellation: the requests
+// waiting on it mustn't fail because that one client went away.
+func (l *userLoader) load(ctx context.Context, id interface{}) (User, error) {
+	query := func(ctx context.Context) (User, error) {
+		var user User
+		err := l.db.WithContext(ctx).First(&user, id).Error
+		return user, err
+	}
+	if l.group == nil {
+		return query(ctx)
+	}
+	// Each caller gets its own copy of the User, so handlers can modify it freely
+	user, err, _ := l.group.Do(fmt.Sprint(id), func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), sharedLookupTimeout)
+		defer cancel()
+		return query(ctx)
+	})
+	return user.(User), err
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestUserLoaderSharedLookupOutlivesTheLeader(t *testing.T) {
+	db := newTestDB(t)
+	alice := createTestUser(t, db, "alice", "secret123")
+	loader := newUserLoader(db, true)
+
+	// Hold the first query until both requests are waiting on it
+	started := make(chan struct{})
+	release := make(chan struct{})
+	blocked := false
+	err := db.Callback().Query().Before("gorm:query").Register("test:block", func(*gorm.DB) {
+		if !blocked {
+			blocked = true
+			close(started)
+			<-release
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leaderDone := make(chan struct{})
+	go func() {
+		defer close(leaderDone)
+		loader.load(leaderCtx, alice.ID)
+	}()
+	<-started
+
+	followerErr := make(chan error, 1)
+	go func() {
+		_, err := loader.load(context.Background(), alice.ID)
+		followerErr <- err
+	}()
+	// singleflight has no hook for "joined", so give the follower a moment to get there
+	time.Sleep(50 * time.Millisecond)
+	cancelLeader()
+	close(release)
+
+	if err := <-followerErr; err != nil {
+		t.Errorf("the follower's lookup failed with the leader's cancellation: %v", err)
+	}
+	<-leaderDone
+}
@@ -0,0 +1,28 @@
+package main
+
+import "strings"
+
+// reservedUsernames can't be registered, since they could be mistaken for staff or routes
+var reservedUsernames = map[string]bool{
+	"admin":         true,
+	"administrator": true,
+	"root":          true,
+	"system":        true,
+	"support":       true,
+	"api":           true,
+	"login":         true,
+	"logout":        true,
+	"register":      true,
+	"static":        true,
+}
+
+// normalizeUsername is the canonical form used when comparing usernames
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// isReservedUsername reports whether username can't be registered. ADMIN_USERNAME isn't exempt:
+// whoever registered it first would become admin, see bootstrapAdmin.
+func isReservedUsername(username string) bool {
+	return reservedUsernames[normalizeUsername(username)]
+}
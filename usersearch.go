@@ -0,0 +1,24 @@
+package main
+
+import (
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// likeEscaper makes LIKE's wildcards literal. '!' is the escape character since a backslash
+// means something else in MySQL string literals.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// withSearch limits a users query to users whose username or email contains q, ignoring case.
+// An empty q leaves the query unfiltered.
+func withSearch(q string) func(*gorm.DB) *gorm.DB {
+	q = strings.TrimSpace(q)
+	pattern := "%" + likeEscaper.Replace(strings.ToLower(q)) + "%"
+	return func(db *gorm.DB) *gorm.DB {
+		if q == "" {
+			return db
+		}
+		return db.Where("(LOWER(users.username) LIKE ? ESCAPE '!' OR LOWER(users.email) LIKE ? ESCAPE '!')", pattern, pattern)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestWithSearch(t *testing.T) {
+	db := newTestDB(t)
+	email := "Carol@Example.com"
+	users := []User{
+		{Username: "alice"},
+		{Username: "malcolm"},
+		{Username: "bob_smith"},
+		{Username: "bobxsmith"},
+		{Username: "100%pure"},
+		{Username: "carol", Email: &email},
+	}
+	if err := db.Create(&users).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		q    string
+		want []string
+	}{
+		{"al", []string{"alice", "malcolm"}},
+		{"AL", []string{"alice", "malcolm"}},
+		{"example.COM", []string{"carol"}},
+		// Wildcards in q only match themselves
+		{"b_s", []string{"bob_smith"}},
+		{"%", []string{"100%pure"}},
+		{"", []string{"alice", "malcolm", "bob_smith", "bobxsmith", "100%pure", "carol"}},
+	}
+	for _, tt := range tests {
+		var found []User
+		if err := db.Scopes(withSearch(tt.q)).Order("id").Find(&found).Error; err != nil {
+			t.Fatalf("q %q: %v", tt.q, err)
+		}
+		var names []string
+		for _, u := range found {
+			names = append(names, u.Username)
+		}
+		if !slices.Equal(names, tt.want) {
+			t.Errorf("q %q found %v, want %v", tt.q, names, tt.want)
+		}
+	}
+}
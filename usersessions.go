@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// UserSession records a login, so every session of a user can be ended at once: the session
+// store can't be searched by user. Sessions are stored by hash, like sqlStorage does.
+type UserSession struct {
+	ID          uint   `gorm:"primaryKey"`
+	UserID      uint   `gorm:"index"`
+	SessionHash string `gorm:"uniqueIndex"`
+	ExpiresAt   int64  // unix seconds, when the login's lifetime ends
+	CreatedAt   time.Time
+}
+
+// recordSession remembers that sessionID is logged in as userID until expiresAt, and drops the
+// user's records whose lifetime is over
+func recordSession(ctx context.Context, db *gorm.DB, userID uint, sessionID string, expiresAt time.Time) error {
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND expires_at <= ?", userID, time.Now().Unix()).Delete(&UserSession{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&UserSession{UserID: userID, SessionHash: hashSessionID(sessionID), ExpiresAt: expiresAt.Unix()}).Error
+	})
+}
+
+// sessionRecorded reports whether sessionID is still recorded for userID, i.e. it hasn't been
+// logged out everywhere since
+func sessionRecorded(ctx context.Context, db *gorm.DB, userID uint, sessionID string) (bool, error) {
+	var count int64
+	err := db.WithContext(ctx).Model(&UserSession{}).
+		Where("user_id = ? AND session_hash = ?", userID, hashSessionID(sessionID)).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// forgetSession removes the record of a session that has ended
+func forgetSession(ctx context.Context, db *gorm.DB, sessionID string) error {
+	return db.WithContext(ctx).Where("session_hash = ?", hashSessionID(sessionID)).Delete(&UserSession{}).Error
+}
+
+// forgetUserSessions removes the records of every session of userID, which loadUser then
+// treats as logged out
+func forgetUserSessions(ctx context.Context, db *gorm.DB, userID uint) error {
+	return db.WithContext(ctx).Where("user_id = ?", userID).Delete(&UserSession{}).Error
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// UserView is the part of a User that templates may see. The model itself stays out of
+// template data so fields like the password hash can't leak into a page by accident.
+type UserView struct {
+	ID       uint
+	Username string
+	Email    string // empty for accounts created before emails were collected
+	// EmailPending is set while Email hasn't been verified
+	EmailPending bool
+	Role         string
+	JoinedAt     time.Time
+	// LastLoginAt is zero if the user hasn't logged in since logins were recorded. It isn't a
+	// pointer, since the date filter only takes a time.Time.
+	LastLoginAt time.Time
+	// AvatarPath is the profile picture under /static, empty to show the default one
+	AvatarPath string
+}
+
+// ToView returns the template-safe representation of the user
+func (u *User) ToView() UserView {
+	view := UserView{
+		ID:           u.ID,
+		Username:     u.Username,
+		EmailPending: u.needsVerification(),
+		Role:         u.Role,
+		JoinedAt:     u.CreatedAt,
+		AvatarPath:   u.AvatarPath,
+	}
+	if u.Email != nil {
+		view.Email = *u.Email
+	}
+	if u.LastLoginAt != nil {
+		view.LastLoginAt = *u.LastLoginAt
+	}
+	return view
+}
+
+// PublicUser is the part of a User the API returns. Like UserView, it keeps the model and
+// its password hash out of responses.
+type PublicUser struct {
+	ID        uint
+	Username  string
+	CreatedAt time.Time
+	Labels    []string
+}
+
+// toPublic returns the API representation of the user; Labels must be preloaded to be included
+func (u *User) toPublic() PublicUser {
+	labels := make([]string, len(u.Labels))
+	for i, label := range u.Labels {
+		labels[i] = label.Name
+	}
+	return PublicUser{
+		ID:        u.ID,
+		Username:  u.Username,
+		CreatedAt: u.CreatedAt,
+		Labels:    labels,
+	}
+}
+
+// currentUser returns the logged-in user loaded by loadUser, or nil. Templates get the
+// UserView under "user" instead.
+func currentUser(c *fiber.Ctx) *User {
+	user, _ := c.Locals("currentUser").(*User)
+	return user
+}
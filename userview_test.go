@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestToPublicOmitsPassword(t *testing.T) {
+	user := User{
+		Model:    gorm.Model{ID: 7, CreatedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)},
+		Username: "alice",
+		Password: "$2a$10$abcdefghijklmnopqrstuv",
+		Labels:   []Label{{Name: "beta"}},
+	}
+
+	body, err := json.Marshal([]PublicUser{user.toPublic()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(strings.ToLower(string(body)), "password") {
+		t.Errorf("public JSON mentions the password: %s", body)
+	}
+	if strings.Contains(string(body), user.Password) {
+		t.Errorf("public JSON contains the password hash: %s", body)
+	}
+
+	want := `[{"ID":7,"Username":"alice","CreatedAt":"2024-05-01T12:00:00Z","Labels":["beta"]}]`
+	if string(body) != want {
+		t.Errorf("public JSON = %s, want %s", body, want)
+	}
+}
+
+func TestUserJSONOmitsPassword(t *testing.T) {
+	body, err := json.Marshal(User{Username: "alice", Password: "$2a$10$abcdefghijklmnopqrstuv"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(strings.ToLower(string(body)), "password") {
+		t.Errorf("User JSON mentions the password: %s", body)
+	}
+}
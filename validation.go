@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ValidationResult collects validation errors keyed by form field, so the same checks can
+// answer an HTML form with flashes and an API client with JSON
+type ValidationResult struct {
+	Errors map[string]string
+	// fields keeps the order errors were added in, for stable flash order
+	fields []string
+}
+
+// Add records an error for field, keeping only the first one per field
+func (r *ValidationResult) Add(field, message string) {
+	if r.Errors == nil {
+		r.Errors = make(map[string]string)
+	}
+	if _, ok := r.Errors[field]; ok {
+		return
+	}
+	r.Errors[field] = message
+	r.fields = append(r.fields, field)
+}
+
+// Merge adds other's errors to r, keeping r's error for fields both have
+func (r *ValidationResult) Merge(other ValidationResult) {
+	for _, field := range other.fields {
+		r.Add(field, other.Errors[field])
+	}
+}
+
+// Valid reports whether no errors were recorded
+func (r ValidationResult) Valid() bool {
+	return len(r.Errors) == 0
+}
+
+// ToFlashes flashes every error as a danger message, in the order they were found
+func (r ValidationResult) ToFlashes(c *fiber.Ctx, flash *FlashManager) {
+	for _, field := range r.fields {
+		flash.Add(c, r.Errors[field], "danger")
+	}
+}
+
+// ToJSON returns the errors as an API response body, {"errors": {"field": "message"}}
+func (r ValidationResult) ToJSON() fiber.Map {
+	errors := make(map[string]string, len(r.Errors))
+	for field, message := range r.Errors {
+		errors[field] = message
+	}
+	return fiber.Map{"errors": errors}
+}
+
+// validateUsername checks a username's shape; whether it's taken or reserved is up to the caller
+func validateUsername(username string) ValidationResult {
+	var result ValidationResult
+	if len(username) < 5 {
+		result.Add("username", "Username must be 5 characters or greater")
+	}
+	return result
+}
+
+// minPasswordLength is the shortest password accepted for new passwords, counted in characters
+const minPasswordLength = 8
+
+// validatePassword checks that a new password is strong enough, returning the first rule it
+// breaks as a message fit to show the user. Existing passwords aren't rechecked at login.
+func validatePassword(pw string) error {
+	if len([]rune(pw)) < minPasswordLength {
+		return fmt.Errorf("Password must be at least %d characters", minPasswordLength)
+	}
+	var hasLetter, hasDigit bool
+	for _, r := range pw {
+		switch {
+		case unicode.IsLetter(r):
+			hasLetter = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		}
+	}
+	if !hasLetter {
+		return errors.New("Password needs at least one letter")
+	}
+	if !hasDigit {
+		return errors.New("Password needs at least one number")
+	}
+	return nil
+}
+
+// validateCredentials checks a username and password submitted to register or reset a password
+func validateCredentials(username, password string) ValidationResult {
+	result := validateUsername(username)
+	if err := validatePassword(password); err != nil {
+		result.Add("password", err.Error())
+	}
+	return result
+}
+
+// validateNewPassword checks a new password and its confirmation, e.g. from /change-password
+func validateNewPassword(password, confirmation string) ValidationResult {
+	var result ValidationResult
+	if err := validatePassword(password); err != nil {
+		result.Add("new_password", err.Error())
+	}
+	if password != confirmation {
+		result.Add("confirm_password", "The new passwords don't match")
+	}
+	return result
+}
+
+// validateEmail checks that email is a bare address like user@example.com, without a display name
+func validateEmail(email string) ValidationResult {
+	var result ValidationResult
+	email = strings.TrimSpace(email)
+	if email == "" {
+		result.Add("email", "Email is required")
+		return result
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		result.Add("email", "Please enter a valid email address")
+	}
+	return result
+}
+
+// normalizeEmail is how emails are stored and compared, so case doesn't create duplicates
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// validateInterests checks interests submitted from the preferences form
+func validateInterests(interests []string) ValidationResult {
+	var result ValidationResult
+	if !allowedValues(interests, interestOptions) {
+		result.Add("interests", "Unknown interest selected")
+	}
+	return result
+}
+
+// validateSecurityAnswers checks the questions and answers submitted from /profile/security
+func validateSecurityAnswers(questions, answers []string) ValidationResult {
+	var result ValidationResult
+	if len(questions) != requiredSecurityAnswers || len(answers) != requiredSecurityAnswers {
+		result.Add("question", "Please answer "+strconv.Itoa(requiredSecurityAnswers)+" questions")
+		return result
+	}
+	seen := make(map[string]bool, len(questions))
+	for _, question := range questions {
+		if seen[question] {
+			result.Add("question", "Please choose different questions from the list")
+		}
+		seen[question] = true
+	}
+	if !allowedValues(questions, securityQuestions) {
+		result.Add("question", "Please choose different questions from the list")
+	}
+	for _, answer := range answers {
+		if len(normalizeAnswer(answer)) < 2 {
+			result.Add("answer", "Answers must be 2 characters or greater")
+		}
+	}
+	return result
+}
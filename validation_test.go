@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestValidatePassword(t *testing.T) {
+	tests := []struct {
+		password string
+		want     string // the error message, "" when the password is accepted
+	}{
+		{"correct1horse", ""},
+		{"abcdefg1", ""},
+		{"пароль123", ""},
+		{"", "Password must be at least 8 characters"},
+		{"abc1", "Password must be at least 8 characters"},
+		{"abcdefgh", "Password needs at least one number"},
+		{"12345678", "Password needs at least one letter"},
+		{"!!!!!!!!", "Password needs at least one letter"},
+	}
+	for _, tt := range tests {
+		err := validatePassword(tt.password)
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != tt.want {
+			t.Errorf("validatePassword(%q) = %q, want %q", tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestValidateNewPasswordReportsStrengthAndMismatch(t *testing.T) {
+	result := validateNewPassword("short", "different")
+	if _, ok := result.Errors["new_password"]; !ok {
+		t.Error("weak password not reported")
+	}
+	if _, ok := result.Errors["confirm_password"]; !ok {
+		t.Error("mismatched confirmation not reported")
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
+)
+
+// Verification emails allowed per account, so /verify/resend can't be used to flood an inbox
+const (
+	resendAttemptsMax    = 3
+	resendAttemptsWindow = 15 * time.Minute
+)
+
+// needsVerification reports whether the user has an email address they haven't confirmed.
+// Accounts from before emails were collected have none to confirm.
+func (u *User) needsVerification() bool {
+	return u.Email != nil && !u.EmailVerified
+}
+
+// newVerificationToken returns a token for the /verify link and the hash to store for it.
+// Only the hash is kept, like session IDs, so the table doesn't hold usable links.
+func newVerificationToken() (token, hash string) {
+	token = secureToken(32)
+	return token, hashSessionID(token)
+}
+
+// verificationLink is the /verify link for token. It starts with PUBLIC_URL rather than the
+// request's host, which the client picks, so a forged Host can't send the token elsewhere.
+func verificationLink(cfg Config, token string) string {
+	return cfg.PublicURL + cfg.BasePath + "/verify?token=" + url.QueryEscape(token)
+}
+
+// sendVerificationEmail mails the user the link that confirms their address
+func sendVerificationEmail(cfg Config, user *User, token string) error {
+	return sendEmail(*user.Email, "Confirm your email address",
+		"Hi "+user.Username+",\n\nOpen this link to confirm your email address:\n"+verificationLink(cfg, token)+"\n")
+}
+
+// redirectToVerify sends a user who hasn't confirmed their email to their profile, which offers
+// a new link, for actions that shouldn't be open to whoever signed up with someone's address
+func redirectToVerify(c *fiber.Ctx, flash *FlashManager) error {
+	flash.Add(c, "Please verify your email address first", "warning")
+	return redirect(c, "/profile")
+}
+
+// setupEmailVerification registers GET /verify, which the emailed link points to, and
+// POST /verify/resend for a fresh link
+func (h *Handlers) setupEmailVerification(app fiber.Router, loggedIn fiber.Handler) {
+	app.Get("/verify", h.verifyEmail)
+
+	// Keyed by account rather than IP, since the flood would be aimed at one address
+	app.Post("/verify/resend", loggedIn, limiter.New(limiter.Config{
+		Max:        resendAttemptsMax,
+		Expiration: resendAttemptsWindow,
+		KeyGenerator: func(c *fiber.Ctx) string {
+			return "user:" + strconv.FormatUint(uint64(currentUser(c).ID), 10)
+		},
+		LimitReached: func(c *fiber.Ctx) error {
+			h.flash.Add(c, "Too many verification emails requested, please try again later", "danger")
+			return redirect(c, "/profile")
+		},
+	}), h.resendVerification)
+}
+
+// verifyEmail answers the emailed link, confirming the address its token was sent to
+func (h *Handlers) verifyEmail(c *fiber.Ctx) error {
+	token := c.Query("token")
+	if token == "" {
+		h.flash.Add(c, "That verification link is invalid or has already been used", "danger")
+		return redirect(c, "/")
+	}
+	if h.cfg.ReadOnly {
+		h.flash.Add(c, readOnlyMessage, "warning")
+		return redirect(c, "/")
+	}
+
+	result := h.db.WithContext(c.UserContext()).Model(&User{}).
+		Where("verification_token = ?", hashSessionID(token)).
+		Updates(map[string]any{"email_verified": true, "verification_token": ""})
+	if result.Error != nil {
+		if isReadOnlyError(result.Error) {
+			h.flash.Add(c, readOnlyMessage, "warning")
+			return redirect(c, "/")
+		}
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		h.flash.Add(c, "That verification link is invalid or has already been used", "danger")
+		return redirect(c, "/")
+	}
+
+	h.flash.Add(c, "Thanks, your email address is verified", "success")
+	if currentUser(c) != nil {
+		return redirect(c, "/profile")
+	}
+	return redirect(c, "/login")
+}
+
+// resendVerification mails the logged-in user a new verification link
+func (h *Handlers) resendVerification(c *fiber.Ctx) error {
+	user := currentUser(c)
+	if !user.needsVerification() {
+		return redirect(c, "/profile")
+	}
+	if h.cfg.ReadOnly {
+		h.flash.Add(c, readOnlyMessage, "warning")
+		return redirect(c, "/profile")
+	}
+
+	// A new token replaces the old one, so only the latest link works
+	token, hash := newVerificationToken()
+	if err := h.db.WithContext(c.UserContext()).Model(user).Update("verification_token", hash).Error; err != nil {
+		if isReadOnlyError(err) {
+			h.flash.Add(c, readOnlyMessage, "warning")
+			return redirect(c, "/profile")
+		}
+		return err
+	}
+	if err := sendVerificationEmail(h.cfg, user, token); err != nil {
+		return err
+	}
+	h.flash.Add(c, "We've sent you a new verification link", "success")
+	return redirect(c, "/profile")
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestVerificationLink(t *testing.T) {
+	cfg := Config{PublicURL: "https://example.com", BasePath: "/app"}
+	if got, want := verificationLink(cfg, "a+b/c"), "https://example.com/app/verify?token=a%2Bb%2Fc"; got != want {
+		t.Errorf("verificationLink = %q, want %q", got, want)
+	}
+}
+
+func TestResendVerificationIsRateLimited(t *testing.T) {
+	h := newTestHandlers(t, Config{PublicURL: "https://example.com"})
+	alice := createTestUser(t, h.db, "alice", "secret123")
+	email := "alice@example.com"
+	if err := h.db.Model(&alice).Update("email", email).Error; err != nil {
+		t.Fatal(err)
+	}
+	app := newTestApp(&alice)
+	h.setupEmailVerification(app, requireLogin(h.flash))
+
+	token := func() string {
+		var user User
+		if err := h.db.First(&user, alice.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		return user.VerificationToken
+	}
+	previous := token()
+	for i := 1; i <= resendAttemptsMax+1; i++ {
+		req := httptest.NewRequest(fiber.MethodPost, "/verify/resend", nil)
+		doRequest(t, app, req)
+		current := token()
+		if sent := current != previous; sent != (i <= resendAttemptsMax) {
+			t.Errorf("request %d: new link sent = %v, want %v", i, sent, i <= resendAttemptsMax)
+		}
+		previous = current
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Actions for suspicious requests, see Config.SuspiciousRequestAction
+const (
+	suspiciousOff   = "off"
+	suspiciousLog   = "log"
+	suspiciousBlock = "block"
+)
+
+// suspiciousRequests flags requests with obviously malicious traits: null bytes or known
+// attack patterns in the URL, oversized headers, or a user agent from a known scanner. It is
+// a cheap first filter, not a replacement for a real WAF. Flagged requests are logged, and
+// rejected with 400 when action is "block".
+func suspiciousRequests(cfg Config) fiber.Handler {
+	badAgents := lowerAll(cfg.SuspiciousUserAgents)
+	badPatterns := lowerAll(cfg.SuspiciousPathPatterns)
+
+	return func(c *fiber.Ctx) error {
+		reason := ""
+		// The request line as sent: once the URI is parsed, Request().RequestURI() is the
+		// normalized one, with "../" already resolved away
+		uri := strings.ToLower(c.OriginalURL())
+		switch {
+		case strings.Contains(uri, "%00") || strings.ContainsRune(uri, 0):
+			reason = "null byte in url"
+		case containsAny(uri, badPatterns):
+			reason = "suspicious url pattern"
+		case containsAny(strings.ToLower(c.Get(fiber.HeaderUserAgent)), badAgents):
+			reason = "blocked user agent"
+		default:
+			// Cookie is left out: it carries every cookie the site and its analytics have set,
+			// which easily adds up past a limit meant for single values
+			c.Request().Header.VisitAll(func(key, value []byte) {
+				if reason == "" && len(value) > cfg.SuspiciousHeaderLength && !strings.EqualFold(string(key), fiber.HeaderCookie) {
+					reason = "oversized header " + string(key)
+				}
+			})
+		}
+		if reason == "" {
+			return c.Next()
+		}
+
+		slog.Warn("suspicious request",
+			"reason", reason,
+			"action", cfg.SuspiciousRequestAction,
+			"method", c.Method(),
+			"path", c.Path(),
+			"ip", c.IP(),
+		)
+		if cfg.SuspiciousRequestAction == suspiciousBlock {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+		return c.Next()
+	}
+}
+
+func containsAny(s string, substrings []string) bool {
+	for _, sub := range substrings {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+func lowerAll(list []string) []string {
+	lower := make([]string, len(list))
+	for i, s := range list {
+		lower[i] = strings.ToLower(s)
+	}
+	return lower
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// realisticCookie is a Cookie header from an ordinary browser visit, past the default
+// SUSPICIOUS_HEADER_LENGTH
+var realisticCookie = strings.Join([]string{
+	"session_id=" + strings.Repeat("s", 43),
+	"csrf_=" + strings.Repeat("c", 43),
+	"flash=" + strings.Repeat("f", 180),
+	"_ga=GA1.1.1234567890.1717171717",
+	"_ga_ABC123=GS1.1.1717171717.3.1.1717171800.0.0.0",
+	"_fbp=fb.1.1717171717000.1234567890",
+	"consent=" + strings.Repeat("v", 2500),
+}, "; ")
+
+func TestSuspiciousRequests(t *testing.T) {
+	cfg := Config{
+		SuspiciousRequestAction: suspiciousBlock,
+		SuspiciousUserAgents:    []string{"sqlmap"},
+		SuspiciousPathPatterns:  []string{"../", "/.env"},
+		SuspiciousHeaderLength:  64,
+	}
+
+	tests := []struct {
+		name    string
+		target  string
+		header  string
+		value   string
+		blocked bool
+	}{
+		{"ordinary request", "/users?search=alice", "", "", false},
+		{"null byte", "/static/a.css%00.php", "", "", true},
+		{"path pattern", "/static/../main.go", "", "", true},
+		{"pattern in another case", "/.ENV", "", "", true},
+		{"scanner user agent", "/", fiber.HeaderUserAgent, "sqlmap/1.8 (https://sqlmap.org)", true},
+		{"oversized header", "/", "X-Padding", strings.Repeat("a", 65), true},
+		// A session, CSRF and flash cookie alongside a few analytics ones
+		{"large cookie", "/", fiber.HeaderCookie, realisticCookie, false},
+	}
+	for _, action := range []string{suspiciousBlock, suspiciousLog} {
+		cfg.SuspiciousRequestAction = action
+		app := fiber.New()
+		app.Use(suspiciousRequests(cfg))
+		app.Use(func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+
+		for _, tt := range tests {
+			req := httptest.NewRequest(fiber.MethodGet, tt.target, nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Logging only flags requests, it never turns them away
+			want := fiber.StatusOK
+			if tt.blocked && action == suspiciousBlock {
+				want = fiber.StatusBadRequest
+			}
+			if resp.StatusCode != want {
+				t.Errorf("%s, %s: answered %d, want %d", action, tt.name, resp.StatusCode, want)
+			}
+		}
+	}
+}